@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/docker/docker/daemon/logger"
 	"github.com/sirupsen/logrus"
@@ -20,7 +21,14 @@ var logCmd = &cobra.Command{
 	Long: `Log a message to Microsoft App Insights. 
 Use this command to test connectivity.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		client, err := insights.New(createLoggerInfo())
+		resolved, err := resolveDefaults("")
+		if err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+		defaults = resolved
+
+		client, err := insights.NewWithDefaults(createLoggerInfo(), defaults)
 		if err != nil {
 			logrus.Error(fmt.Sprintf("Failed to create logging client. %v", err))
 			panic(err)
@@ -59,19 +67,15 @@ Use this command to test connectivity.`,
 func sendMessage(client logger.Logger, line string) {
 	msg := logger.NewMessage()
 	msg.Line = []byte(line)
+	msg.Timestamp = time.Now()
 	client.Log(msg)
 }
 
 func createLoggerInfo() logger.Info {
-	config := make(map[string]string, 8)
-	config[constants.EndpointKey] = constants.Endpoint
-	config[constants.TokenKey] = constants.Token
-	config[constants.InsecureSkipVerifyKey] = constants.InsecureSkipVerifyStr
-	config[constants.GzipCompressionKey] = constants.GzipCompressionStr
-	config[constants.GzipCompressionLevelKey] = constants.GzipCompressionLevelStr
-	config[constants.VerifyConnectionKey] = constants.VerifyConnectionStr
-	config[constants.BatchSizeKey] = constants.BatchSizeStr
-	config[constants.BatchIntervalKey] = constants.BatchIntervalStr
+	// Every other option falls back to the defaults filled from the flags
+	config := map[string]string{
+		constants.TokenKey: defaults.Token,
+	}
 
 	return logger.Info{
 		Config: config,
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/michael.golfi/appinsights/constants"
+	"gitlab.com/michael.golfi/appinsights/insights"
+)
+
+var optionsFormat string
+
+// optionsCmd represents the options command
+var optionsCmd = &cobra.Command{
+	Use:   "options",
+	Short: "Print the supported log options",
+	Long: `Print every log option supported by the plugin with its type, default,
+valid range and description. The markdown format is used to generate the README.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch optionsFormat {
+		case "table":
+			err = printOptionsTable(os.Stdout)
+		case "json":
+			err = printOptionsJSON(os.Stdout)
+		case "markdown":
+			err = printOptionsMarkdown(os.Stdout)
+		default:
+			err = fmt.Errorf("unknown format %q, expected table, json or markdown", optionsFormat)
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func printOptionsTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "OPTION\tTYPE\tDEFAULT\tRANGE\tLABEL\tDESCRIPTION")
+	for _, opt := range insights.Options {
+		label := ""
+		if opt.Label {
+			label = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", opt.Name, opt.Type, opt.Default, opt.Range(), label, opt.Description)
+	}
+	return w.Flush()
+}
+
+func printOptionsJSON(out io.Writer) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(insights.Options)
+}
+
+func printOptionsMarkdown(out io.Writer) error {
+	fmt.Fprintln(out, "| Option | Type | Default | Range | Description |")
+	fmt.Fprintln(out, "|--------|------|---------|-------|-------------|")
+	for _, opt := range insights.Options {
+		def := ""
+		if opt.Default != "" {
+			def = fmt.Sprintf("`%s`", opt.Default)
+		}
+
+		description := opt.Description
+		if opt.Label {
+			description += fmt.Sprintf(" (label `%s%s`)", constants.LabelPrefix, opt.Name)
+		}
+		fmt.Fprintf(out, "| %s | %s | %s | %s | %s |\n", opt.Name, opt.Type, def, strings.Replace(opt.Range(), "|", "\\|", -1), description)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(optionsCmd)
+	optionsCmd.Flags().StringVarP(&optionsFormat, "format", "f", "table", "Output format: [table, json, markdown]")
+}
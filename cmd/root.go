@@ -5,9 +5,14 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
-	"gitlab.com/michael.golfi/appinsights/constants"
+	"github.com/spf13/pflag"
+	"gitlab.com/michael.golfi/appinsights/insights"
 )
 
+// defaults holds the plugin-wide configuration resolved from the defaults file and the command line flags.
+// Log opts set on a container take precedence over these values.
+var defaults = insights.DefaultConfig()
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "appinsights",
@@ -18,6 +23,26 @@ The plugin supports the docker log command and also supports buffering and retry
 for remote App Insights in the case of network disconnection`,
 }
 
+// resolveDefaults layers the defaults file at path, when set, and then the flags
+// given on the command line over the built-in defaults
+func resolveDefaults(path string) (insights.Config, error) {
+	resolved := insights.DefaultConfig()
+	if path != "" {
+		var err error
+		if resolved, err = insights.LoadDefaults(path, resolved); err != nil {
+			return insights.Config{}, err
+		}
+	}
+
+	flags := make(map[string]string)
+	rootCmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed {
+			flags[flag.Name] = flag.Value.String()
+		}
+	})
+	return insights.MergeDefaults(resolved, flags, insights.SourceFlag)
+}
+
 // Execute is the entrypoint to the command execution context.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
@@ -27,12 +52,13 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.PersistentFlags().StringVarP(&constants.Endpoint, constants.EndpointKey, "", constants.Endpoint, "The URL for App Insights")
-	rootCmd.PersistentFlags().StringVarP(&constants.Token, constants.TokenKey, "k", constants.Token, "Insights Instrumentation Key")
-	rootCmd.PersistentFlags().StringVarP(&constants.InsecureSkipVerifyStr, constants.InsecureSkipVerifyKey, "", constants.InsecureSkipVerifyStr, "Skip verifying the SSL certificate")
-	rootCmd.PersistentFlags().StringVarP(&constants.GzipCompressionStr, constants.GzipCompressionKey, "c", constants.GzipCompressionStr, "Enable GZip compression")
-	rootCmd.PersistentFlags().StringVarP(&constants.GzipCompressionLevelStr, constants.GzipCompressionLevelKey, "", constants.GzipCompressionLevelStr, "GZip compression level")
-	rootCmd.PersistentFlags().StringVarP(&constants.VerifyConnectionStr, constants.VerifyConnectionKey, "", constants.VerifyConnectionStr, "Verify the connection to App Insights on start")
-	rootCmd.PersistentFlags().StringVarP(&constants.BatchSizeStr, constants.BatchSizeKey, "", constants.BatchSizeStr, "Message Batch Size")
-	rootCmd.PersistentFlags().StringVarP(&constants.BatchIntervalStr, constants.BatchIntervalKey, "", constants.BatchIntervalStr, "Message Batch Interval")
+	// Every log opt in the registry can also be set as a plugin-wide default on the command line
+	flags := rootCmd.PersistentFlags()
+	for _, opt := range insights.Options {
+		if opt.Type == insights.TypeBool {
+			flags.BoolP(opt.Name, opt.Shorthand, opt.Default == "true", opt.Description)
+		} else {
+			flags.StringP(opt.Name, opt.Shorthand, opt.Default, opt.Description)
+		}
+	}
 }
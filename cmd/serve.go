@@ -3,10 +3,13 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/docker/go-plugins-helpers/sdk"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"gitlab.com/michael.golfi/appinsights/constants"
 	"gitlab.com/michael.golfi/appinsights/handler"
 )
 
@@ -34,14 +37,40 @@ var serveCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		resolved, err := resolveDefaults(os.Getenv(constants.DefaultsFileEnv))
+		if err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+		defaults = resolved
+		logrus.WithField("sources", defaults.Sources).Debug("Resolved plugin defaults")
+
+		driver := handler.NewDriver(defaults)
+		go reloadOnHangup(driver)
+
 		h := sdk.NewHandler(`{"Implements": ["LoggingDriver"]}`)
-		handler.Handle(&h, handler.NewDriver())
+		handler.Handle(&h, driver)
 		if err := h.ServeUnix("appinsights", 0); err != nil {
 			panic(err)
 		}
 	},
 }
 
+// reloadOnHangup re-reads the plugin defaults on every SIGHUP and applies them to running containers
+func reloadOnHangup(driver *handler.Driver) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		resolved, err := resolveDefaults(os.Getenv(constants.DefaultsFileEnv))
+		if err != nil {
+			logrus.WithError(err).Error("Could not reload plugin defaults, keeping the previous ones")
+			continue
+		}
+		logrus.WithField("sources", resolved.Sources).Info("Reloading plugin defaults")
+		driver.Reload(resolved)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(serveCmd)
 	serveCmd.Flags().StringVarP(&level, "verbose", "v", "info", "Sets log level: [info, debug, warn, error]")
@@ -2,9 +2,15 @@ package constants
 
 import "time"
 
-var (
+// Version of the plugin, set at build time
+var Version = "dev"
+
+const (
 	DriverName = "appinsights"
 
+	// DefaultsFileEnv names the plugin environment variable holding the path of the defaults file
+	DefaultsFileEnv = "DEFAULTS_FILE"
+
 	// Application Insights Configuration Keys
 	EndpointKey             = "endpoint"
 	TokenKey                = "token"
@@ -14,18 +20,96 @@ var (
 	VerifyConnectionKey     = "verify-connection"
 	BatchSizeKey            = "batch-size"
 	BatchIntervalKey        = "batch-interval"
+	ConnectionStringKey     = "connection-string"
+	TokenFileKey            = "token-file"
+	RoleKey                 = "role"
+	MinSeverityKey          = "min-severity"
+	SampleRateKey           = "sample-rate"
+	SeveritySampleRateKey   = "severity-sample-rate"
+	MaxItemsPerSecondKey    = "max-items-per-second"
+	SamplingFloorKey        = "sampling-floor"
+	StdoutSeverityKey       = "stdout-severity"
+	StderrSeverityKey       = "stderr-severity"
+	SeverityRulesKey        = "severity-rules"
+	SeverityFieldKey        = "severity-field"
+	FormatKey               = "format"
+	MessageFieldKey         = "message-field"
+	TimestampFieldKey       = "timestamp-field"
+	NumericMeasurementsKey  = "numeric-measurements"
+	ParsePatternKey         = "parse-pattern"
+	LineTimestampKey        = "line-timestamp"
+	RoleSourceKey           = "role-source"
+	RoleInstanceSourceKey   = "role-instance-source"
+	TraceparentFieldKey     = "traceparent-field"
+	OperationIDFieldKey     = "operation-id-field"
+	OperationParentFieldKey = "operation-parent-field"
+	ExceptionsKey           = "exceptions"
+	ExceptionTimeoutKey     = "exception-timeout"
+	EventFieldKey           = "event-field"
+	EventPrefixKey          = "event-prefix"
+	EventPatternKey         = "event-pattern"
+	MetricFormatKey         = "metric-format"
+	MetricRulesKey          = "metric-rules"
+	AccessLogKey            = "access-log"
+	IncludeFieldsKey        = "include-fields"
+	ExcludeFieldsKey        = "exclude-fields"
+	EnvAllowlistKey         = "env-allowlist"
+	OversizeKey             = "oversize"
+	RedactKey               = "redact"
+	RedactPatternsKey       = "redact-patterns"
+	RedactModeKey           = "redact-mode"
+	RedactLocalKey          = "redact-local"
+	RedactHashKeyKey        = "redact-hash-key"
+
+	// LabelPrefix is the prefix of container labels that override log opts, e.g. appinsights.role
+	LabelPrefix = "appinsights."
 
-	// Application Insights String Configuration
-	Endpoint                = "https://dc.services.visualstudio.com/v2/track"
-	Token                   = ""
-	VerifyConnectionStr     = "true"
-	InsecureSkipVerifyStr   = "false"
-	GzipCompressionStr      = "false"
-	GzipCompressionLevelStr = "0"
-	BatchSizeStr            = "1024"
-	BatchIntervalStr        = "5s"
-
-	// Application Insights Configuration
+	// Application Insights Default Configuration
+	Endpoint             = "https://dc.services.visualstudio.com/v2/track"
+	Token                = ""
+	ConnectionString     = ""
+	TokenFile            = ""
+	TokenFileRefresh     = 10 * time.Second
+	RedactedValue        = "<redacted>"
+	Role                 = ""
+	MinSeverity          = "verbose"
+	SampleRate           = 100.0
+	SeveritySampleRate   = ""
+	MaxItemsPerSecond    = 0.0
+	SamplingFloor        = "error"
+	StdoutSeverity       = "information"
+	StderrSeverity       = "warning"
+	SeverityRules        = ""
+	SeverityField        = "level,severity"
+	Format               = "text"
+	MessageField         = "message,msg"
+	TimestampField       = "timestamp,time,ts"
+	NumericMeasurements  = false
+	ParsePattern         = ""
+	LineTimestamp        = false
+	RoleSource           = "compose-service,swarm-service,container-name"
+	RoleInstanceSource   = "container-id"
+	TraceparentField     = "traceparent"
+	OperationIDField     = "trace_id,traceId,operation_Id"
+	OperationParentField = "span_id,spanId,operation_ParentId"
+	Exceptions           = false
+	ExceptionTimeout     = time.Second
+	EventField           = "ai_event"
+	EventPrefix          = ""
+	EventPattern         = ""
+	MetricFormat         = ""
+	MetricRules          = ""
+	AccessLog            = ""
+	IncludeFields        = "ContainerID,ContainerName,ContainerEntrypoint,ContainerImageID,ContainerImageName,LogPath,DaemonName,ContainerCreated,ContainerArgs,ContainerLabels"
+	ExcludeFields        = ""
+	EnvAllowlist         = ""
+	Oversize             = "truncate"
+	Redact               = "bearer,jwt,connection-string"
+	RedactPatterns       = ""
+	RedactMode           = "mask"
+	RedactLocal          = false
+	RedactHashKey        = ""
+	IngestionPath        = "/v2/track"
 	VerifyConnection     = true
 	InsecureSkipVerify   = false
 	GzipCompression      = false
@@ -33,7 +117,7 @@ var (
 	BatchSize            = 1024
 	BatchInterval        = 5 * time.Second
 
-	BufferMaximum = 10 * BatchSize
+	BufferMaximum     = 10 * BatchSize
 	StreamChannelSize = 4 * BatchSize
-	SendTimeout = 30 * time.Second
+	SendTimeout       = 30 * time.Second
 )
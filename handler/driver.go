@@ -23,9 +23,21 @@ import (
 
 // Driver maintains a mutex for synchronizing map access for tracking logpairs and maintains the logging interface for each container
 type Driver struct {
-	logs   *logPairMap
-	idx    *logPairMap
-	logger logger.Logger
+	logs     *logPairMap
+	idx      *logPairMap
+	logger   logger.Logger
+	lock     sync.RWMutex
+	defaults insights.Config
+}
+
+// localRedactor is implemented by loggers that redact the lines written to the local json file
+type localRedactor interface {
+	RedactLocalLine(line []byte) []byte
+}
+
+// reconfigurable is implemented by loggers that can apply new plugin defaults while running
+type reconfigurable interface {
+	Reconfigure(defaults insights.Config, check *insights.ConnectionCheck) error
 }
 
 type logPair struct {
@@ -37,11 +49,13 @@ type logPair struct {
 	info       logger.Info
 }
 
-// NewDriver creates a driver which initializes the logpairs for each container
-func NewDriver() *Driver {
+// NewDriver creates a driver which initializes the logpairs for each container.
+// The defaults apply to every container that does not set the matching log opt.
+func NewDriver(defaults insights.Config) *Driver {
 	return &Driver{
-		logs: newLogPairMap(),
-		idx:  newLogPairMap(),
+		logs:     newLogPairMap(),
+		idx:      newLogPairMap(),
+		defaults: defaults,
 	}
 }
 
@@ -63,7 +77,11 @@ func (d *Driver) StartLogging(file string, logCtx logger.Info) error {
 		return errors.Wrap(err, "error creating jsonfile logger")
 	}
 
-	sl, err := insights.New(logCtx)
+	d.lock.RLock()
+	defaults := d.defaults
+	d.lock.RUnlock()
+
+	sl, err := insights.NewWithDefaults(logCtx, defaults)
 	if err != nil {
 		return errors.Wrap(err, "error creating appinsights logger")
 	}
@@ -108,6 +126,31 @@ func (d *Driver) StopLogging(file string) error {
 	return nil
 }
 
+// Reload replaces the plugin defaults and applies them to the loggers of running containers.
+// The defaults are not applied when their endpoint cannot be reached. Every endpoint in use is
+// verified once, and containers that fail to reconfigure keep logging with their previous settings.
+func (d *Driver) Reload(defaults insights.Config) {
+	check := insights.NewConnectionCheck()
+	if err := check.Verify(defaults); err != nil {
+		logrus.WithError(err).Error("Could not verify the connection of the reloaded defaults, keeping the previous ones")
+		return
+	}
+
+	d.lock.Lock()
+	d.defaults = defaults
+	d.lock.Unlock()
+
+	d.logs.Range(func(file string, lf *logPair) {
+		rl, ok := lf.aiLog.(reconfigurable)
+		if !ok {
+			return
+		}
+		if err := rl.Reconfigure(defaults, check); err != nil {
+			logrus.WithField("id", lf.info.ContainerID).WithField("file", file).WithError(err).Error("Could not reload AI logging")
+		}
+	})
+}
+
 func (d *Driver) consumeLog(file string, lf *logPair) {
 	dec := protoio.NewUint32DelimitedReader(lf.stream, binary.BigEndian, 1e6)
 	defer dec.Close()
@@ -134,6 +177,10 @@ func (d *Driver) consumeLog(file string, lf *logPair) {
 		smsg.Partial = buf.Partial
 		smsg.Timestamp = time.Unix(0, buf.TimeNano)
 
+		if rl, ok := lf.aiLog.(localRedactor); ok {
+			msg.Line = rl.RedactLocalLine(msg.Line)
+		}
+
 		lf.closedCond.RLock()
 		if lf.isOpen {
 			if err := lf.fileLog.Log(&msg); err != nil {
@@ -146,7 +193,7 @@ func (d *Driver) consumeLog(file string, lf *logPair) {
 				continue
 			}
 		} else {
-			logrus.WithField("id", lf.info).WithField("file", file).Info("stop consuming log")
+			logrus.WithField("id", lf.info.ContainerID).WithField("file", file).Info("stop consuming log")
 			lf.closedCond.RUnlock()
 			return
 		}
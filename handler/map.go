@@ -30,4 +30,18 @@ func (rm *logPairMap) Store(key string, value *logPair) {
 	rm.Lock()
 	rm.internal[key] = value
 	rm.Unlock()
-}
\ No newline at end of file
+}
+
+// Range calls f for each pair, on a snapshot so f may use the map
+func (rm *logPairMap) Range(f func(key string, value *logPair)) {
+	rm.RLock()
+	pairs := make(map[string]*logPair, len(rm.internal))
+	for key, value := range rm.internal {
+		pairs[key] = value
+	}
+	rm.RUnlock()
+
+	for key, value := range pairs {
+		f(key, value)
+	}
+}
@@ -19,3 +19,21 @@ func TestLogPairMap(t *testing.T) {
 	require.False(t, ok)
 	require.Nil(t, val)
 }
+
+func TestLogPairMapRange(t *testing.T) {
+	lm := newLogPairMap()
+	lm.Store("Hello", &logPair{})
+	lm.Store("World", &logPair{})
+
+	seen := make(map[string]bool)
+	lm.Range(func(key string, value *logPair) {
+		require.NotNil(t, value)
+		// Storing while ranging must not deadlock
+		lm.Store(key+"!", value)
+		seen[key] = true
+	})
+
+	require.Len(t, seen, 2)
+	require.True(t, seen["Hello"])
+	require.True(t, seen["World"])
+}
@@ -0,0 +1,95 @@
+package insights
+
+import (
+	"sync"
+	"time"
+
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+const (
+	// adaptiveWindow is the sliding window the ingest rate is measured over
+	adaptiveWindow = 30 * time.Second
+	// minAdaptiveRate keeps a trickle of telemetry under any load
+	minAdaptiveRate = 0.1
+)
+
+// adaptiveRateMetric reports the percentage of telemetry kept by adaptive sampling
+var adaptiveRateMetric = constants.DriverName + " sampling percentage"
+
+// adaptiveSampler adjusts the sampling percentage so the telemetry of a container stays under
+// a target number of items per second. Items kept by the fixed rates and below the sampling floor
+// are counted by the logger, the worker moves the window and adjusts the percentage on every flush.
+type adaptiveSampler struct {
+	lock    sync.Mutex
+	rate    float64
+	current int
+	// last is the end of the previous bucket, or the time the first item was counted
+	last    time.Time
+	buckets []adaptiveBucket
+}
+
+// adaptiveBucket holds the items counted between two flushes of the worker
+type adaptiveBucket struct {
+	start time.Time
+	end   time.Time
+	count int
+}
+
+// percentage counts an item and returns the percentage of items currently kept
+func (s *adaptiveSampler) percentage() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.last.IsZero() {
+		s.last = time.Now()
+	}
+	s.current++
+	if s.rate == 0 {
+		return 100
+	}
+	return s.rate
+}
+
+// adjust closes the current bucket and sets the percentage from the items per second over the window
+func (s *adaptiveSampler) adjust(now time.Time, target float64) float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.last.IsZero() {
+		s.rate = 100
+		return s.rate
+	}
+
+	s.buckets = append(s.buckets, adaptiveBucket{start: s.last, end: now, count: s.current})
+	s.last, s.current = now, 0
+	for len(s.buckets) > 1 && now.Sub(s.buckets[0].end) > adaptiveWindow {
+		s.buckets = s.buckets[1:]
+	}
+
+	total := 0
+	for _, bucket := range s.buckets {
+		total += bucket.count
+	}
+	// A burst right after the first item must not be taken for a sustained rate
+	elapsed := now.Sub(s.buckets[0].start)
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+
+	s.rate = 100
+	if perSecond := float64(total) / elapsed.Seconds(); perSecond > target {
+		s.rate = target / perSecond * 100
+	}
+	if s.rate < minAdaptiveRate {
+		s.rate = minAdaptiveRate
+	}
+	return s.rate
+}
+
+// adjustSampling updates the adaptive sampling percentage and records it as a metric
+func (l *insightsLogger) adjustSampling() {
+	if l.config.MaxItemsPerSecond <= 0 {
+		return
+	}
+	rate := l.sampler.adjust(time.Now(), l.config.MaxItemsPerSecond)
+	l.metrics.add(adaptiveRateMetric, nil, rate)
+}
@@ -0,0 +1,93 @@
+package insights
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveSamplerAdjust(t *testing.T) {
+	var sampler adaptiveSampler
+	require.Equal(t, 100.0, sampler.adjust(time.Now(), 10))
+
+	for i := 0; i < 200; i++ {
+		require.Equal(t, 100.0, sampler.percentage())
+	}
+	start := sampler.last
+
+	// 200 items over 10 seconds is twice the target
+	require.InDelta(t, 50, sampler.adjust(start.Add(10*time.Second), 10), 0.01)
+	require.InDelta(t, 50, sampler.percentage(), 0.01)
+
+	// Quiet intervals bring the rate back up once the burst leaves the window
+	require.InDelta(t, 100*10/(201/20.0), sampler.adjust(start.Add(20*time.Second), 10), 0.01)
+	require.Equal(t, 100.0, sampler.adjust(start.Add(60*time.Second), 10))
+}
+
+func TestKeepAdaptiveFloor(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxItemsPerSecond = 1
+	insightsLog := insightsLogger{config: config}
+	insightsLog.sampler.rate = minAdaptiveRate
+
+	msg := logger.NewMessage()
+	msg.Line = []byte("Some Message")
+	envelope := insightsLog.createInsightsMessage(msg)
+	message, _ := messageData(envelope)
+
+	message.SeverityLevel = contracts.Error
+	require.True(t, insightsLog.keep(envelope))
+	require.Equal(t, 100.0, envelope.SampleRate)
+
+	message.SeverityLevel = contracts.Information
+	insightsLog.keep(envelope)
+	require.Equal(t, minAdaptiveRate, envelope.SampleRate)
+
+	insightsLog.adjustSampling()
+	require.Len(t, insightsLog.metricEnvelopes(), 1)
+}
+
+func TestKeepAdaptiveAfterFixedRate(t *testing.T) {
+	config := DefaultConfig()
+	config.SampleRate = 50
+	config.MaxItemsPerSecond = 10
+	insightsLog := insightsLogger{config: config}
+
+	msg := logger.NewMessage()
+	msg.Line = []byte("Some Message")
+	logItems := func(n int) int {
+		kept := 0
+		for i := 0; i < n; i++ {
+			envelope := insightsLog.createInsightsMessage(msg)
+			if insightsLog.keep(envelope) {
+				kept++
+			}
+		}
+		return kept
+	}
+
+	// Errors are at the floor and are not counted
+	errorMsg := logger.NewMessage()
+	errorMsg.Line = []byte("Some Message")
+	errorMsg.Source = "stderr"
+	insightsLog.config.StderrSeverity = contracts.Error
+	for i := 0; i < 100; i++ {
+		insightsLog.keep(insightsLog.createInsightsMessage(errorMsg))
+	}
+	require.Equal(t, 0, insightsLog.sampler.current)
+
+	// 4000 items over 20 seconds, of which the fixed rate keeps 100 per second
+	logItems(4000)
+	insightsLog.sampler.adjust(insightsLog.sampler.last.Add(20*time.Second), config.MaxItemsPerSecond)
+	require.InDelta(t, 10, insightsLog.sampler.rate, 1)
+
+	// At the same load the items sent come close to the target of 200 over 20 seconds
+	require.InDelta(t, 200, logItems(4000), 60)
+	envelope := insightsLog.createInsightsMessage(msg)
+	for !insightsLog.keep(envelope) {
+	}
+	require.InDelta(t, 50*insightsLog.sampler.rate/100, envelope.SampleRate, 0.001)
+}
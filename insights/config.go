@@ -0,0 +1,100 @@
+package insights
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+)
+
+// Origins of a configuration value, from lowest to highest precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceFlag    = "flag"
+	SourceLogOpt  = "log-opt"
+	SourceLabel   = "label"
+)
+
+// Config holds the settings of a single appinsights logger.
+// Each logger keeps its own copy so containers never share or overwrite each other's settings.
+type Config struct {
+	ConnectionString      string
+	Endpoint              string
+	Token                 string
+	TokenFile             string
+	InsecureSkipVerify    bool
+	GzipCompression       bool
+	GzipCompressionLevel  int
+	VerifyConnection      bool
+	BatchSize             int
+	BatchInterval         time.Duration
+	Role                  string
+	MinSeverity           ai.SeverityLevel
+	SampleRate            float64
+	SeveritySampleRates   map[ai.SeverityLevel]float64
+	MaxItemsPerSecond     float64
+	SamplingFloor         ai.SeverityLevel
+	StdoutSeverity        ai.SeverityLevel
+	StderrSeverity        ai.SeverityLevel
+	SeverityRules         []SeverityRule
+	SeverityFields        []string
+	Format                string
+	MessageFields         []string
+	TimestampFields       []string
+	NumericMeasurements   bool
+	ParsePattern          *regexp.Regexp
+	LineTimestamp         bool
+	RoleSources           []string
+	RoleInstanceSource    string
+	TraceparentFields     []string
+	OperationIDFields     []string
+	OperationParentFields []string
+	Exceptions            bool
+	ExceptionTimeout      time.Duration
+	EventFields           []string
+	EventPrefix           string
+	EventPattern          *regexp.Regexp
+	MetricFormats         []string
+	MetricRules           []MetricRule
+	AccessLogs            []string
+	IncludeFields         []string
+	ExcludeFields         []string
+	EnvAllowlist          []string
+	Oversize              string
+	Redact                []string
+	RedactPatterns        []*regexp.Regexp
+	RedactMode            string
+	RedactLocal           bool
+	RedactHashKey         string
+
+	// Sources records where each option that is not a built-in default came from, keyed by option name
+	Sources map[string]string
+}
+
+// DefaultConfig returns the built-in configuration used when a log opt is not set
+func DefaultConfig() Config {
+	defaults := make(map[string]string, len(Options))
+	for _, opt := range Options {
+		defaults[opt.Name] = opt.Default
+	}
+
+	var config Config
+	info := logger.Info{Config: defaults}
+	for _, opt := range Options {
+		if err := opt.apply(info, &config); err != nil {
+			panic(fmt.Sprintf("invalid default for %s: %v", opt.Name, err))
+		}
+	}
+	return config
+}
+
+// Source returns where the value of the option named key came from
+func (c Config) Source(key string) string {
+	if source, ok := c.Sources[key]; ok {
+		return source
+	}
+	return SourceDefault
+}
@@ -0,0 +1,53 @@
+package insights
+
+import (
+	"regexp"
+	"strings"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// traceparentPattern matches a W3C traceparent header, version-traceid-parentid-flags
+var traceparentPattern = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}`)
+
+// operationTags returns the operation tags of the trace context found in the fields of a parsed line.
+// A valid traceparent wins over separate operation id and parent id fields.
+func (l *insightsLogger) operationTags(fields map[string]string) map[string]string {
+	for _, name := range l.config.TraceparentFields {
+		if id, parentID, ok := parseTraceparent(fields[name]); ok {
+			return map[string]string{ai.OperationId: id, ai.OperationParentId: parentID}
+		}
+	}
+
+	tags := make(map[string]string, 2)
+	if id := firstValue(fields, l.config.OperationIDFields); id != "" {
+		tags[ai.OperationId] = id
+		if parentID := firstValue(fields, l.config.OperationParentFields); parentID != "" {
+			tags[ai.OperationParentId] = parentID
+		}
+	}
+	return tags
+}
+
+// parseTraceparent returns the trace id and parent id of a traceparent such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(val string) (string, string, bool) {
+	match := traceparentPattern.FindStringSubmatch(strings.TrimSpace(val))
+	if match == nil || match[1] == "ff" {
+		return "", "", false
+	}
+	if strings.Trim(match[2], "0") == "" || strings.Trim(match[3], "0") == "" {
+		return "", "", false
+	}
+	return match[2], match[3], true
+}
+
+// firstValue returns the first non empty value of names in fields
+func firstValue(fields map[string]string, names []string) string {
+	for _, name := range names {
+		if val := fields[name]; val != "" {
+			return val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package insights
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/daemon/logger"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+// MergeDefaults validates opts and layers them over defaults, recording source as their origin
+func MergeDefaults(defaults Config, opts map[string]string, source string) (Config, error) {
+	config, errs := parseLogOpt(logger.Info{Config: opts}, defaults, source)
+	if err := errs.errOrNil(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// LoadDefaults reads the plugin-wide defaults file at path and layers it over defaults.
+// The file is a flat mapping of log opt names to values, written either as JSON or YAML.
+func LoadDefaults(path string, defaults Config) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: failed to read defaults file: %v", constants.DriverName, err)
+	}
+
+	opts, err := parseDefaultsFile(path, data)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: failed to parse defaults file %s: %v", constants.DriverName, path, err)
+	}
+	return MergeDefaults(defaults, opts, SourceFile+":"+path)
+}
+
+func parseDefaultsFile(path string, data []byte) (map[string]string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return parseDefaultsJSON(data)
+	case ".yml", ".yaml":
+		return parseDefaultsYAML(data)
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parseDefaultsJSON(data)
+	}
+	return parseDefaultsYAML(data)
+}
+
+func parseDefaultsJSON(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	opts := make(map[string]string, len(raw))
+	for key, val := range raw {
+		switch v := val.(type) {
+		case string:
+			opts[key] = v
+		case bool:
+			opts[key] = strconv.FormatBool(v)
+		case float64:
+			opts[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case nil:
+			opts[key] = ""
+		default:
+			return nil, fmt.Errorf("value of %s must be a string, number or boolean", key)
+		}
+	}
+	return opts, nil
+}
+
+// parseDefaultsYAML reads the subset of YAML used by defaults files:
+// one "key: value" pair per line with optional quoting and comments.
+func parseDefaultsYAML(data []byte) (map[string]string, error) {
+	opts := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: nested values are not supported", lineNo)
+		}
+
+		pair := strings.SplitN(trimmed, ":", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return nil, fmt.Errorf("line %d: expected key: value", lineNo)
+		}
+
+		val, err := parseYAMLScalar(strings.TrimSpace(pair[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		opts[strings.TrimSpace(pair[0])] = val
+	}
+	return opts, scanner.Err()
+}
+
+func parseYAMLScalar(val string) (string, error) {
+	switch {
+	case strings.HasPrefix(val, `"`):
+		end := strings.LastIndex(val, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return strconv.Unquote(val[:end+1])
+	case strings.HasPrefix(val, "'"):
+		end := strings.LastIndex(val, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return strings.Replace(val[1:end], "''", "'", -1), nil
+	}
+
+	// Strip trailing comments from plain values
+	if idx := strings.Index(val, " #"); idx >= 0 {
+		val = strings.TrimSpace(val[:idx])
+	}
+	if val == "~" || val == "null" {
+		return "", nil
+	}
+	return val, nil
+}
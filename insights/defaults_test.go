@@ -0,0 +1,87 @@
+package insights
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+func writeDefaultsFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "appinsights")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadDefaultsYAML(t *testing.T) {
+	path := writeDefaultsFile(t, "defaults.yml", `---
+# plugin-wide defaults
+token: "file token"
+batch-size: 512 # per batch
+batch-interval: '10s'
+gzip: true
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := LoadDefaults(path, DefaultConfig())
+	require.NoError(t, err)
+	require.Equal(t, "file token", config.Token)
+	require.Equal(t, 512, config.BatchSize)
+	require.Equal(t, 10*time.Second, config.BatchInterval)
+	require.True(t, config.GzipCompression)
+	require.Equal(t, constants.Endpoint, config.Endpoint)
+
+	require.Equal(t, SourceFile+":"+path, config.Source(constants.TokenKey))
+	require.Equal(t, SourceDefault, config.Source(constants.EndpointKey))
+
+	// Log opts take precedence over the defaults file
+	merged, err := InitializeEnv(logger.Info{
+		Config: map[string]string{
+			constants.BatchSizeKey: "8",
+		},
+	}, config)
+	require.NoError(t, err)
+	require.Equal(t, "file token", merged.Token)
+	require.Equal(t, 8, merged.BatchSize)
+	require.Equal(t, SourceLogOpt, merged.Source(constants.BatchSizeKey))
+	require.Equal(t, SourceFile+":"+path, merged.Source(constants.TokenKey))
+}
+
+func TestLoadDefaultsJSON(t *testing.T) {
+	path := writeDefaultsFile(t, "defaults.json", `{"token": "file token", "batch-size": 512, "verify-connection": false}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config, err := LoadDefaults(path, DefaultConfig())
+	require.NoError(t, err)
+	require.Equal(t, "file token", config.Token)
+	require.Equal(t, 512, config.BatchSize)
+	require.False(t, config.VerifyConnection)
+}
+
+func TestLoadDefaultsInvalid(t *testing.T) {
+	files := map[string]string{
+		"unknown.yml":   "some param: value",
+		"range.yml":     "gzip-level: 42",
+		"nested.yml":    "token:\n  nested: value",
+		"malformed.yml": "token",
+		"nested.json":   `{"token": {"nested": "value"}}`,
+	}
+
+	for name, content := range files {
+		path := writeDefaultsFile(t, name, content)
+		_, err := LoadDefaults(path, DefaultConfig())
+		os.RemoveAll(filepath.Dir(path))
+		require.Error(t, err, name)
+	}
+
+	_, err := LoadDefaults("/does/not/exist.yml", DefaultConfig())
+	require.Error(t, err)
+}
@@ -0,0 +1,95 @@
+package insights
+
+import (
+	"encoding/json"
+	"strings"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+)
+
+const (
+	// eventGroup is the group of the event pattern naming the event
+	eventGroup = "event"
+	// eventPrefixField holds the name of an event following the event prefix
+	eventPrefixField = "ai_event"
+)
+
+// matchEvent turns lines starting with the event prefix or matching the event pattern into events,
+// such as EVENT user_signup {"plan": "free", "seats": 3}
+func (l *insightsLogger) matchEvent(line []byte, parsed parsedLine) parsedLine {
+	if parsed.Event != "" {
+		return parsed
+	}
+
+	raw := string(line)
+	if prefix := l.config.EventPrefix; prefix != "" && strings.HasPrefix(raw, prefix) {
+		parts := strings.SplitN(strings.TrimSpace(raw[len(prefix):]), " ", 2)
+		if parts[0] != "" {
+			fields := map[string]interface{}{}
+			if len(parts) == 2 {
+				fields = decodeEventPayload(strings.TrimSpace(parts[1]))
+			}
+			fields[eventPrefixField] = parts[0]
+			return l.mapFields(fields, raw, fieldNames{event: []string{eventPrefixField}, timestamp: l.config.TimestampFields})
+		}
+	}
+
+	if l.config.EventPattern != nil {
+		if match := l.config.EventPattern.FindStringSubmatch(raw); match != nil {
+			fields := make(map[string]interface{}, len(match))
+			for i, name := range l.config.EventPattern.SubexpNames() {
+				if name == "" || match[i] == "" {
+					continue
+				}
+				if finiteNumber(match[i]) {
+					fields[name] = json.Number(match[i])
+				} else {
+					fields[name] = match[i]
+				}
+			}
+			if fields[eventGroup] != nil {
+				return l.mapFields(fields, raw, fieldNames{event: []string{eventGroup}, timestamp: []string{"timestamp"}})
+			}
+		}
+	}
+	return parsed
+}
+
+// decodeEventPayload decodes the properties following the name of an event,
+// keeping them as a single property when they are neither JSON nor key=value pairs
+func decodeEventPayload(payload string) map[string]interface{} {
+	if payload == "" {
+		return map[string]interface{}{}
+	}
+	if fields, err := decodeJSONFields([]byte(payload)); err == nil {
+		return fields
+	}
+	if fields, err := decodeLogfmtFields([]byte(payload)); err == nil {
+		return fields
+	}
+	return map[string]interface{}{"payload": payload}
+}
+
+// createInsightsEvent creates custom event telemetry from a line naming an event
+func (l *insightsLogger) createInsightsEvent(msg *logger.Message, line parsedLine) *ai.Envelope {
+	ctx := l.messageProperties(msg)
+	for key, val := range line.Properties {
+		if _, ok := ctx[key]; !ok {
+			ctx[key] = val
+		}
+	}
+
+	data := &ai.EventData{
+		Ver:          2,
+		Name:         line.Event,
+		Properties:   ctx,
+		Measurements: line.Measurements,
+	}
+
+	tags := l.createTags()
+	for tag, val := range l.operationTags(line.Properties) {
+		tags[tag] = val
+	}
+	return l.createEnvelope("EventData", data, tags, l.envelopeTime(msg, line))
+}
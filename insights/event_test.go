@@ -0,0 +1,79 @@
+package insights
+
+import (
+	"testing"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+func createEvent(t *testing.T, opts map[string]string, line string) *contracts.EventData {
+	insightsLog := newParsingLogger(t, opts)
+	msg := logger.NewMessage()
+	msg.Line = []byte(line)
+	envelope := insightsLog.createInsightsMessage(msg)
+
+	require.Equal(t, "Microsoft.ApplicationInsights.EventData", envelope.Name)
+	data := envelope.Data.(*contracts.Data)
+	require.Equal(t, "EventData", data.BaseType)
+	return data.BaseData.(*contracts.EventData)
+}
+
+func TestEventPrefix(t *testing.T) {
+	opts := map[string]string{constants.EventPrefixKey: "EVENT "}
+
+	event := createEvent(t, opts, `EVENT user_signup {"plan": "free", "seats": 3}`)
+	require.Equal(t, "user_signup", event.Name)
+	require.Equal(t, "free", event.Properties["plan"])
+	require.Equal(t, map[string]float64{"seats": 3}, event.Measurements)
+
+	event = createEvent(t, opts, `EVENT order_placed total=12.5 currency=EUR`)
+	require.Equal(t, "order_placed", event.Name)
+	require.Equal(t, "EUR", event.Properties["currency"])
+	require.Equal(t, map[string]float64{"total": 12.5}, event.Measurements)
+
+	event = createEvent(t, opts, `EVENT cache_cleared`)
+	require.Equal(t, "cache_cleared", event.Name)
+	require.Empty(t, event.Measurements)
+}
+
+func TestEventPattern(t *testing.T) {
+	event := createEvent(t, map[string]string{
+		constants.EventPatternKey: `^audit: (?P<event>\w+) by (?P<user>\w+) in (?P<ms>\d+)ms$`,
+	}, "audit: login by alice in 42ms")
+	require.Equal(t, "login", event.Name)
+	require.Equal(t, "alice", event.Properties["user"])
+	require.Equal(t, map[string]float64{"ms": 42}, event.Measurements)
+
+	event = createEvent(t, map[string]string{
+		constants.EventPatternKey: `^audit: (?P<event>\w+) in (?P<ms>\S+)ms$`,
+	}, "audit: login in Infms")
+	require.Equal(t, "Inf", event.Properties["ms"])
+	require.Empty(t, event.Measurements)
+
+	_, err := InitializeEnv(logger.Info{Config: map[string]string{
+		constants.TokenKey:        "some token",
+		constants.EventPatternKey: `^audit: (?P<name>\w+)$`,
+	}}, DefaultConfig())
+	require.Error(t, err)
+}
+
+func TestEventField(t *testing.T) {
+	event := createEvent(t, map[string]string{constants.FormatKey: FormatJSON},
+		`{"ai_event": "checkout", "message": "cart checked out", "items": 2, "user": {"id": "u1"}}`)
+	require.Equal(t, "checkout", event.Name)
+	require.Equal(t, "cart checked out", event.Properties["message"])
+	require.Equal(t, "u1", event.Properties["user.id"])
+	require.Equal(t, map[string]float64{"items": 2}, event.Measurements)
+
+	// Lines without an event are still sent as messages
+	insightsLog := newParsingLogger(t, map[string]string{constants.FormatKey: FormatJSON})
+	msg := logger.NewMessage()
+	msg.Line = []byte(`{"message": "not an event"}`)
+	envelope := insightsLog.createInsightsMessage(msg)
+	data, ok := messageData(envelope)
+	require.True(t, ok)
+	require.Equal(t, "not an event", data.Message)
+}
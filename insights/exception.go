@@ -0,0 +1,352 @@
+package insights
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// stackTraceFormat recognises the stack traces written by one runtime
+type stackTraceFormat struct {
+	// start matches the first line of a stack trace
+	start *regexp.Regexp
+	// continues reports whether line, following prev, belongs to the stack trace
+	continues func(prev, line string) bool
+	// parse builds the exceptions of a complete stack trace, the raised exception first
+	parse func(lines []string) []*ai.ExceptionDetails
+}
+
+// stackTraceFormats are tried in order on lines that are not part of a stack trace
+var stackTraceFormats = []*stackTraceFormat{
+	{start: goStart, continues: goContinues, parse: parseGoStackTrace},
+	{start: pythonStart, continues: pythonContinues, parse: parsePythonStackTrace},
+	{start: jvmStart, continues: jvmContinues, parse: parseJVMStackTrace},
+	{start: nodeStart, continues: nodeContinues, parse: parseNodeStackTrace},
+}
+
+// detectStackTrace returns the format of the stack trace starting at line, if any
+func detectStackTrace(line string) *stackTraceFormat {
+	for _, format := range stackTraceFormats {
+		if format.start.MatchString(line) {
+			return format
+		}
+	}
+	return nil
+}
+
+// Go panics and fatal errors
+var (
+	goStart     = regexp.MustCompile(`^(panic|fatal error): (.*)$`)
+	goGoroutine = regexp.MustCompile(`^goroutine \d+ \[.*\]:$`)
+	goFunc      = regexp.MustCompile(`^[^\s()]+\(.*\)$|^created by \S+(?: in goroutine \d+)?$`)
+	goFile      = regexp.MustCompile(`^\t(.+):(\d+)(?: \+0x[0-9a-f]+)?$`)
+)
+
+func goContinues(prev, line string) bool {
+	return line == "" || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "[signal ") ||
+		strings.HasPrefix(line, "panic: ") || goGoroutine.MatchString(line) || goFunc.MatchString(line)
+}
+
+func parseGoStackTrace(lines []string) []*ai.ExceptionDetails {
+	match := goStart.FindStringSubmatch(lines[0])
+	exception := newExceptionDetails(0, match[1], match[2])
+
+	// Only the frames of the goroutine that panicked are parsed
+	goroutines := 0
+	for i := 1; i < len(lines); i++ {
+		if goGoroutine.MatchString(lines[i]) {
+			if goroutines++; goroutines > 1 {
+				break
+			}
+			continue
+		}
+
+		file := goFile.FindStringSubmatch(lines[i])
+		if file == nil || goroutines == 0 {
+			continue
+		}
+		method := strings.TrimPrefix(lines[i-1], "created by ")
+		method = strings.Split(method, " in goroutine ")[0]
+		if paren := strings.LastIndex(method, "("); paren > 0 && strings.HasSuffix(method, ")") {
+			method = method[:paren]
+		}
+		addStackFrame(exception, method, "", file[1], file[2])
+	}
+	return []*ai.ExceptionDetails{exception}
+}
+
+// Python tracebacks, including chained exceptions
+var (
+	pythonStart     = regexp.MustCompile(`^Traceback \(most recent call last\):$`)
+	pythonFrame     = regexp.MustCompile(`^\s+File "(.+)", line (\d+), in (.+)$`)
+	pythonException = regexp.MustCompile(`^([A-Za-z_][\w.]*)(?:: (.*))?$`)
+)
+
+func pythonContinues(prev, line string) bool {
+	switch {
+	case line == "", strings.HasPrefix(line, " "), pythonStart.MatchString(line):
+		return true
+	case strings.HasPrefix(line, "During handling of the above exception"),
+		strings.HasPrefix(line, "The above exception was the direct cause"):
+		return true
+	}
+	// The exception follows the indented frames
+	return strings.HasPrefix(prev, " ") && pythonException.MatchString(line)
+}
+
+func parsePythonStackTrace(lines []string) []*ai.ExceptionDetails {
+	// Every traceback of a chain ends with its exception, the last one was raised
+	var chain []*ai.ExceptionDetails
+	var frames []string
+	for _, line := range lines {
+		if pythonStart.MatchString(line) {
+			frames = nil
+			continue
+		}
+		if pythonFrame.MatchString(line) {
+			frames = append(frames, line)
+			continue
+		}
+		if strings.HasPrefix(line, " ") || frames == nil {
+			continue
+		}
+
+		match := pythonException.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		exception := newExceptionDetails(0, match[1], match[2])
+		// The most recent call is last in a traceback
+		for i := len(frames) - 1; i >= 0; i-- {
+			frame := pythonFrame.FindStringSubmatch(frames[i])
+			addStackFrame(exception, frame[3], "", frame[1], frame[2])
+		}
+		chain = append(chain, exception)
+		frames = nil
+	}
+	return outermostFirst(chain)
+}
+
+// Java and .NET exceptions with their causes and inner exceptions
+var (
+	jvmStart    = regexp.MustCompile(`^(?:Exception in thread "[^"]*" |Unhandled [Ee]xception[.:] )?((?:[\w$]+\.)+[\w$]*(?:Exception|Error|Throwable))(?:: (.*))?$`)
+	jvmCause    = regexp.MustCompile(`^\s*(?:Caused by: |---> )((?:[\w$]+\.)*[\w$]+)(?:: (.*))?$`)
+	javaFrame   = regexp.MustCompile(`^\s+at ([\w$./<>-]+)\.([\w$<>]+)\(([\w$-]+\.(?:java|kt|scala|groovy|clj)|Native Method|Unknown Source|<generated>)(?::(\d+))?\)$`)
+	dotnetFrame = regexp.MustCompile(`^\s+at (.+?)(?: in (.+):line (\d+))?$`)
+)
+
+func jvmContinues(prev, line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "at ") || strings.HasPrefix(trimmed, "... ") ||
+		strings.HasPrefix(trimmed, "Caused by: ") || strings.HasPrefix(trimmed, "Suppressed: ") ||
+		strings.HasPrefix(trimmed, "---> ") || strings.HasPrefix(trimmed, "--- End of ")
+}
+
+func parseJVMStackTrace(lines []string) []*ai.ExceptionDetails {
+	match := jvmStart.FindStringSubmatch(lines[0])
+	exception := newExceptionDetails(0, match[1], match[2])
+	exceptions := []*ai.ExceptionDetails{exception}
+
+	for _, line := range lines[1:] {
+		if cause := jvmCause.FindStringSubmatch(line); cause != nil {
+			exception = newExceptionDetails(len(exceptions), cause[1], cause[2])
+			exception.OuterId = exceptions[len(exceptions)-1].Id
+			exceptions = append(exceptions, exception)
+			continue
+		}
+
+		if frame := javaFrame.FindStringSubmatch(line); frame != nil {
+			// Frames of Java 9 modules are prefixed by the module, e.g. java.base/java.lang.Thread
+			assembly, class := "", frame[1]
+			if slash := strings.Index(class, "/"); slash >= 0 {
+				assembly, class = class[:slash], class[slash+1:]
+			}
+			addStackFrame(exception, class+"."+frame[2], assembly, frame[3], frame[4])
+		} else if frame := dotnetFrame.FindStringSubmatch(line); frame != nil {
+			addStackFrame(exception, frame[1], "", frame[2], frame[3])
+		}
+	}
+	return exceptions
+}
+
+// Node.js errors
+var (
+	nodeStart = regexp.MustCompile(`^(?:Uncaught )?((?:[A-Z]\w*)?(?:Error|Exception))(?:: (.*))?$`)
+	nodeFrame = regexp.MustCompile(`^\s+at (?:(.+?) \()?(.+?):(\d+):\d+\)?$`)
+)
+
+func nodeContinues(prev, line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "at ")
+}
+
+func parseNodeStackTrace(lines []string) []*ai.ExceptionDetails {
+	match := nodeStart.FindStringSubmatch(lines[0])
+	exception := newExceptionDetails(0, match[1], match[2])
+	for _, line := range lines[1:] {
+		if frame := nodeFrame.FindStringSubmatch(line); frame != nil {
+			method := frame[1]
+			if method == "" {
+				method = "<anonymous>"
+			}
+			addStackFrame(exception, method, "", frame[2], frame[3])
+		}
+	}
+	return []*ai.ExceptionDetails{exception}
+}
+
+func newExceptionDetails(id int, typeName, message string) *ai.ExceptionDetails {
+	return &ai.ExceptionDetails{
+		Id:           id,
+		TypeName:     typeName,
+		Message:      message,
+		HasFullStack: true,
+	}
+}
+
+func addStackFrame(exception *ai.ExceptionDetails, method, assembly, file, line string) {
+	number, _ := strconv.Atoi(line)
+	exception.ParsedStack = append(exception.ParsedStack, &ai.StackFrame{
+		Level:    len(exception.ParsedStack),
+		Method:   method,
+		Assembly: assembly,
+		FileName: file,
+		Line:     number,
+	})
+}
+
+// outermostFirst orders a chain of exceptions listed from the first raised to the last
+// so the last raised comes first and every other exception is nested in the one after it
+func outermostFirst(chain []*ai.ExceptionDetails) []*ai.ExceptionDetails {
+	exceptions := make([]*ai.ExceptionDetails, 0, len(chain))
+	for i := len(chain) - 1; i >= 0; i-- {
+		exception := chain[i]
+		exception.Id = len(exceptions)
+		if exception.Id > 0 {
+			exception.OuterId = exception.Id - 1
+		}
+		exceptions = append(exceptions, exception)
+	}
+	return exceptions
+}
+
+// stackFrames counts the parsed frames of exceptions
+func stackFrames(exceptions []*ai.ExceptionDetails) int {
+	frames := 0
+	for _, exception := range exceptions {
+		frames += len(exception.ParsedStack)
+	}
+	return frames
+}
+
+// stackTraces groups the lines of the stack traces written to each stream of a container
+type stackTraces struct {
+	lock    sync.Mutex
+	pending map[string]*stackTrace
+}
+
+// stackTrace is a stack trace still being written
+type stackTrace struct {
+	format   *stackTraceFormat
+	messages []*logger.Message
+	timer    *time.Timer
+}
+
+func (t *stackTrace) lines() []string {
+	lines := make([]string, len(t.messages))
+	for i, msg := range t.messages {
+		lines[i] = string(msg.Line)
+	}
+	return lines
+}
+
+// logStackTrace collects the lines of stack traces. A stack trace is sent once a line that does
+// not belong to it is written to the same stream or no line was written for the exception timeout.
+func (l *insightsLogger) logStackTrace(msg *logger.Message) error {
+	l.traces.lock.Lock()
+	defer l.traces.lock.Unlock()
+	defer logger.PutMessage(msg)
+
+	var err error
+	line := string(msg.Line)
+	if trace := l.traces.pending[msg.Source]; trace != nil {
+		prev := trace.messages[len(trace.messages)-1]
+		if trace.format.continues(string(prev.Line), line) {
+			trace.messages = append(trace.messages, copyMessage(msg))
+			trace.timer.Reset(l.config.ExceptionTimeout)
+			return nil
+		}
+		err = l.flushStackTrace(msg.Source)
+	}
+
+	if format := detectStackTrace(line); format != nil {
+		source := msg.Source
+		trace := &stackTrace{format: format, messages: []*logger.Message{copyMessage(msg)}}
+		trace.timer = time.AfterFunc(l.config.ExceptionTimeout, func() {
+			l.traces.lock.Lock()
+			defer l.traces.lock.Unlock()
+			if l.traces.pending[source] == trace {
+				l.flushStackTrace(source)
+			}
+		})
+		if l.traces.pending == nil {
+			l.traces.pending = make(map[string]*stackTrace)
+		}
+		l.traces.pending[source] = trace
+		return err
+	}
+
+	if sendErr := l.send(l.createInsightsMessage(msg)); err == nil {
+		err = sendErr
+	}
+	return err
+}
+
+// flushStackTrace sends the pending stack trace of source. Lines that turn out not to be
+// a stack trace, such as a lone error message, are sent as messages. The lock must be held.
+func (l *insightsLogger) flushStackTrace(source string) error {
+	trace := l.traces.pending[source]
+	delete(l.traces.pending, source)
+	trace.timer.Stop()
+
+	exceptions := trace.format.parse(trace.lines())
+	if stackFrames(exceptions) > 0 {
+		return l.send(l.createInsightsException(trace.messages[0], exceptions, trace.lines()))
+	}
+
+	var err error
+	for _, msg := range trace.messages {
+		if sendErr := l.send(l.createInsightsMessage(msg)); err == nil {
+			err = sendErr
+		}
+	}
+	return err
+}
+
+// flushStackTraces sends every pending stack trace
+func (l *insightsLogger) flushStackTraces() {
+	l.traces.lock.Lock()
+	defer l.traces.lock.Unlock()
+	for source := range l.traces.pending {
+		if err := l.flushStackTrace(source); err != nil {
+			logrus.WithError(err).WithField("id", l.logCtx.ContainerID).Warn("Could not send a stack trace")
+		}
+	}
+}
+
+// copyMessage copies a message that is kept after it is returned to the pool
+func copyMessage(msg *logger.Message) *logger.Message {
+	return &logger.Message{
+		Line:      append([]byte(nil), msg.Line...),
+		Source:    msg.Source,
+		Timestamp: msg.Timestamp,
+		Attrs:     append([]backend.LogAttr(nil), msg.Attrs...),
+		Partial:   msg.Partial,
+	}
+}
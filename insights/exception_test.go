@@ -0,0 +1,211 @@
+package insights
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+func parseStackTrace(t *testing.T, trace string) []*contracts.ExceptionDetails {
+	lines := strings.Split(trace, "\n")
+	format := detectStackTrace(lines[0])
+	require.NotNil(t, format, lines[0])
+	for i := 1; i < len(lines); i++ {
+		require.True(t, format.continues(lines[i-1], lines[i]), lines[i])
+	}
+	return format.parse(lines)
+}
+
+func requireFrame(t *testing.T, frame *contracts.StackFrame, method, file string, line int) {
+	require.Equal(t, method, frame.Method)
+	require.Equal(t, file, frame.FileName)
+	require.Equal(t, line, frame.Line)
+}
+
+func TestParseGoStackTrace(t *testing.T) {
+	exceptions := parseStackTrace(t, `panic: runtime error: index out of range
+
+goroutine 1 [running]:
+main.(*server).handle(0xc42000e1e0, 0x0)
+	/go/src/app/server.go:42 +0x1d
+main.main()
+	/go/src/app/main.go:12 +0x30
+
+goroutine 5 [chan receive]:
+main.worker()
+	/go/src/app/worker.go:8 +0x10`)
+
+	require.Len(t, exceptions, 1)
+	require.Equal(t, "panic", exceptions[0].TypeName)
+	require.Equal(t, "runtime error: index out of range", exceptions[0].Message)
+	require.Len(t, exceptions[0].ParsedStack, 2)
+	requireFrame(t, exceptions[0].ParsedStack[0], "main.(*server).handle", "/go/src/app/server.go", 42)
+	requireFrame(t, exceptions[0].ParsedStack[1], "main.main", "/go/src/app/main.go", 12)
+}
+
+func TestParseJavaStackTrace(t *testing.T) {
+	exceptions := parseStackTrace(t, `Exception in thread "main" java.lang.IllegalStateException: could not start
+	at com.example.App.start(App.java:21)
+	at java.base/java.lang.Thread.run(Thread.java:829)
+Caused by: java.io.FileNotFoundException: config.yml
+	at com.example.Config.load(Config.java:7)
+	... 2 more`)
+
+	require.Len(t, exceptions, 2)
+	require.Equal(t, "java.lang.IllegalStateException", exceptions[0].TypeName)
+	require.Equal(t, "could not start", exceptions[0].Message)
+	requireFrame(t, exceptions[0].ParsedStack[0], "com.example.App.start", "App.java", 21)
+	requireFrame(t, exceptions[0].ParsedStack[1], "java.lang.Thread.run", "Thread.java", 829)
+	require.Equal(t, "java.base", exceptions[0].ParsedStack[1].Assembly)
+
+	require.Equal(t, "java.io.FileNotFoundException", exceptions[1].TypeName)
+	require.Equal(t, 1, exceptions[1].Id)
+	require.Equal(t, 0, exceptions[1].OuterId)
+	requireFrame(t, exceptions[1].ParsedStack[0], "com.example.Config.load", "Config.java", 7)
+}
+
+func TestParseDotNetStackTrace(t *testing.T) {
+	exceptions := parseStackTrace(t, `Unhandled exception. System.InvalidOperationException: Sequence contains no elements
+ ---> System.ArgumentNullException: Value cannot be null.
+   at App.Repository.Find(String id) in /src/App/Repository.cs:line 31
+   --- End of inner exception stack trace ---
+   at App.Program.Main(String[] args) in /src/App/Program.cs:line 12
+   at App.Program.Run(String s)`)
+
+	require.Len(t, exceptions, 2)
+	require.Equal(t, "System.InvalidOperationException", exceptions[0].TypeName)
+	require.Equal(t, "System.ArgumentNullException", exceptions[1].TypeName)
+	requireFrame(t, exceptions[1].ParsedStack[0], "App.Repository.Find(String id)", "/src/App/Repository.cs", 31)
+	requireFrame(t, exceptions[1].ParsedStack[1], "App.Program.Main(String[] args)", "/src/App/Program.cs", 12)
+	requireFrame(t, exceptions[1].ParsedStack[2], "App.Program.Run(String s)", "", 0)
+}
+
+func TestParsePythonStackTrace(t *testing.T) {
+	exceptions := parseStackTrace(t, `Traceback (most recent call last):
+  File "/app/main.py", line 10, in load
+    return json.loads(data)
+KeyError: 'name'
+
+During handling of the above exception, another exception occurred:
+
+Traceback (most recent call last):
+  File "/app/main.py", line 20, in <module>
+    main()
+  File "/app/main.py", line 14, in main
+    raise ValueError("invalid config")
+ValueError: invalid config`)
+
+	require.Len(t, exceptions, 2)
+	require.Equal(t, "ValueError", exceptions[0].TypeName)
+	require.Equal(t, "invalid config", exceptions[0].Message)
+	requireFrame(t, exceptions[0].ParsedStack[0], "main", "/app/main.py", 14)
+	requireFrame(t, exceptions[0].ParsedStack[1], "<module>", "/app/main.py", 20)
+
+	require.Equal(t, "KeyError", exceptions[1].TypeName)
+	require.Equal(t, 0, exceptions[1].OuterId)
+	requireFrame(t, exceptions[1].ParsedStack[0], "load", "/app/main.py", 10)
+}
+
+func TestParseNodeStackTrace(t *testing.T) {
+	exceptions := parseStackTrace(t, `TypeError: Cannot read property 'id' of undefined
+    at getUser (/app/users.js:12:18)
+    at /app/server.js:40:5`)
+
+	require.Len(t, exceptions, 1)
+	require.Equal(t, "TypeError", exceptions[0].TypeName)
+	requireFrame(t, exceptions[0].ParsedStack[0], "getUser", "/app/users.js", 12)
+	requireFrame(t, exceptions[0].ParsedStack[1], "<anonymous>", "/app/server.js", 40)
+}
+
+func newCollectingLogger(t *testing.T) *insightsLogger {
+	config, err := InitializeEnv(logger.Info{Config: map[string]string{
+		constants.TokenKey:            "some token",
+		constants.ExceptionsKey:       "true",
+		constants.ExceptionTimeoutKey: "50ms",
+	}}, DefaultConfig())
+	require.NoError(t, err)
+	return &insightsLogger{config: config, stream: make(chan *contracts.Envelope, 10)}
+}
+
+func logLines(t *testing.T, l *insightsLogger, source string, lines ...string) {
+	for _, line := range lines {
+		msg := logger.NewMessage()
+		msg.Source = source
+		msg.Line = []byte(line)
+		msg.Timestamp = time.Now()
+		require.NoError(t, l.Log(msg))
+	}
+}
+
+func TestLogStackTrace(t *testing.T) {
+	insightsLog := newCollectingLogger(t)
+
+	logLines(t, insightsLog, "stderr",
+		"starting",
+		"TypeError: boom",
+		"    at run (/app/index.js:3:9)",
+	)
+	logLines(t, insightsLog, "stdout", "unrelated stdout line")
+	logLines(t, insightsLog, "stderr", "next line")
+
+	envelope := <-insightsLog.stream
+	require.Equal(t, "starting", envelope.Data.(*contracts.Data).BaseData.(*contracts.MessageData).Message)
+
+	envelope = <-insightsLog.stream
+	require.Equal(t, "unrelated stdout line", envelope.Data.(*contracts.Data).BaseData.(*contracts.MessageData).Message)
+
+	envelope = <-insightsLog.stream
+	require.Equal(t, "Microsoft.ApplicationInsights.ExceptionData", envelope.Name)
+	exception := envelope.Data.(*contracts.Data).BaseData.(*contracts.ExceptionData)
+	require.Equal(t, contracts.Error, exception.SeverityLevel)
+	require.Equal(t, "TypeError", exception.Exceptions[0].TypeName)
+	require.Equal(t, "TypeError: boom\n    at run (/app/index.js:3:9)", exception.Exceptions[0].Stack)
+	require.Equal(t, "stderr", exception.Properties["Source"])
+
+	envelope = <-insightsLog.stream
+	require.Equal(t, "next line", envelope.Data.(*contracts.Data).BaseData.(*contracts.MessageData).Message)
+}
+
+func TestLogStackTraceTimeout(t *testing.T) {
+	insightsLog := newCollectingLogger(t)
+
+	// A lone error line is sent as a message once no stack trace follows
+	logLines(t, insightsLog, "stderr", "Error: connection refused")
+	select {
+	case envelope := <-insightsLog.stream:
+		require.Equal(t, "Error: connection refused", envelope.Data.(*contracts.Data).BaseData.(*contracts.MessageData).Message)
+	case <-time.After(time.Second):
+		require.Fail(t, "pending line was not sent")
+	}
+
+	logLines(t, insightsLog, "stderr", "panic: boom", "", "goroutine 1 [running]:", "main.main()", "\t/app/main.go:5 +0x1")
+	select {
+	case envelope := <-insightsLog.stream:
+		require.Equal(t, "Microsoft.ApplicationInsights.ExceptionData", envelope.Name)
+	case <-time.After(time.Second):
+		require.Fail(t, "pending stack trace was not sent")
+	}
+}
+
+func TestLogStackTraceOperation(t *testing.T) {
+	insightsLog := newCollectingLogger(t)
+	insightsLog.config.ParsePattern = regexp.MustCompile(`^(?P<message>.*) trace_id=(?P<trace_id>[0-9a-f]+) at (?P<timestamp>\S+)$`)
+	insightsLog.config.LineTimestamp = true
+
+	logLines(t, insightsLog, "stderr",
+		"TypeError: boom trace_id=4bf92f3577b34da6a3ce929d0e0e4736 at 2018-03-01T10:00:00Z",
+		"    at run (/app/index.js:3:9)",
+		"next line",
+	)
+
+	envelope := <-insightsLog.stream
+	require.Equal(t, "Microsoft.ApplicationInsights.ExceptionData", envelope.Name)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", envelope.Tags[contracts.OperationId])
+	require.Equal(t, "2018-03-01T10:00:00Z", envelope.Time)
+}
@@ -0,0 +1,101 @@
+package insights
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// keep decides whether an envelope is sent, dropping messages below the minimum severity
+// and sampling the rest at the fixed or severity rate. Items below the sampling floor that are kept
+// by that rate feed adaptive sampling, which samples them again to stay under the target. The rate
+// the envelope was sampled at is stamped on it so item counts can be extrapolated, and telemetry
+// of an operation is sampled consistently.
+func (l *insightsLogger) keep(envelope *ai.Envelope) bool {
+	severity, ok := envelopeSeverity(envelope)
+	if ok && severity < l.config.MinSeverity {
+		return false
+	}
+
+	rate := l.config.SampleRate
+	if severityRate, found := l.config.SeveritySampleRates[severity]; ok && found {
+		rate = severityRate
+	}
+
+	// The same score decides both samplings, so the adaptive one keeps a share of what the fixed rate kept
+	score := rand.Float64() * 100
+	if id := envelope.Tags[ai.OperationId]; id != "" {
+		score = samplingScore(id)
+	}
+	envelope.SampleRate = rate
+	if rate < 100 && score >= rate {
+		return false
+	}
+
+	if l.config.MaxItemsPerSecond > 0 && (!ok || severity < l.config.SamplingFloor) {
+		rate = rate * l.sampler.percentage() / 100
+		envelope.SampleRate = rate
+	}
+	return rate >= 100 || score < rate
+}
+
+// samplingScore hashes an operation id to a score between 0 and 100 as the Application Insights SDKs do,
+// so the plugin keeps or drops the same operations as the services instrumented with them
+func samplingScore(id string) float64 {
+	for len(id) < 8 {
+		id += id
+	}
+
+	var hash int32 = 5381
+	for _, c := range id {
+		hash = (hash << 5) + hash + int32(c)
+	}
+	if hash == math.MinInt32 {
+		hash = math.MaxInt32
+	} else if hash < 0 {
+		hash = -hash
+	}
+	return float64(hash) / math.MaxInt32 * 100
+}
+
+// parseSeveritySampleRates parses rates such as verbose=10,information=50
+func parseSeveritySampleRates(val string) (map[ai.SeverityLevel]float64, error) {
+	rates := make(map[ai.SeverityLevel]float64)
+	for _, pair := range strings.Split(val, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q must be of the form severity=rate", pair)
+		}
+		level, err := parseSeverity(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || rate < 0 || rate > 100 {
+			return nil, fmt.Errorf("rate of %s must be a percentage between 0 and 100, received %q", parts[0], parts[1])
+		}
+		rates[level] = rate
+	}
+	return rates, nil
+}
+
+// envelopeSeverity returns the severity of message and exception telemetry
+func envelopeSeverity(envelope *ai.Envelope) (ai.SeverityLevel, bool) {
+	if message, ok := messageData(envelope); ok {
+		return message.SeverityLevel, true
+	}
+	if data, ok := envelope.Data.(*ai.Data); ok {
+		if exception, ok := data.BaseData.(*ai.ExceptionData); ok {
+			return exception.SeverityLevel, true
+		}
+	}
+	return ai.Verbose, false
+}
@@ -0,0 +1,108 @@
+package insights
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepMinSeverity(t *testing.T) {
+	config := DefaultConfig()
+	config.MinSeverity = contracts.Warning
+	insightsLog := insightsLogger{config: config}
+
+	msg := logger.NewMessage()
+	msg.Line = []byte("Some Message")
+	envelope := insightsLog.createInsightsMessage(msg)
+	require.False(t, insightsLog.keep(envelope))
+
+	envelope.Data.(*contracts.Data).BaseData.(*contracts.MessageData).SeverityLevel = contracts.Error
+	require.True(t, insightsLog.keep(envelope))
+}
+
+func TestKeepSampleRate(t *testing.T) {
+	config := DefaultConfig()
+	config.Role = "web"
+	config.SampleRate = 0
+	insightsLog := insightsLogger{config: config, tags: contextTags(logger.Info{}, config)}
+
+	msg := logger.NewMessage()
+	msg.Line = []byte("Some Message")
+	envelope := insightsLog.createInsightsMessage(msg)
+	require.Equal(t, 0.0, envelope.SampleRate)
+	require.Equal(t, "web", envelope.Tags[contracts.CloudRole])
+	require.False(t, insightsLog.keep(envelope))
+
+	insightsLog.config.SampleRate = 100
+	require.True(t, insightsLog.keep(envelope))
+
+	insightsLog.config.SampleRate = 50
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		if insightsLog.keep(envelope) {
+			kept++
+		}
+	}
+	require.InDelta(t, 500, kept, 150)
+}
+
+func TestKeepSeveritySampleRate(t *testing.T) {
+	config := DefaultConfig()
+	config.SampleRate = 50
+	config.SeveritySampleRates = map[contracts.SeverityLevel]float64{contracts.Verbose: 0, contracts.Error: 100}
+	insightsLog := insightsLogger{config: config}
+
+	msg := logger.NewMessage()
+	msg.Line = []byte("Some Message")
+	envelope := insightsLog.createInsightsMessage(msg)
+	message, _ := messageData(envelope)
+
+	message.SeverityLevel = contracts.Verbose
+	require.False(t, insightsLog.keep(envelope))
+	require.Equal(t, 0.0, envelope.SampleRate)
+
+	message.SeverityLevel = contracts.Error
+	require.True(t, insightsLog.keep(envelope))
+	require.Equal(t, 100.0, envelope.SampleRate)
+
+	message.SeverityLevel = contracts.Information
+	insightsLog.keep(envelope)
+	require.Equal(t, 50.0, envelope.SampleRate)
+
+	_, err := parseSeveritySampleRates("verbose=10,error=101")
+	require.Error(t, err)
+}
+
+func TestKeepOperationConsistently(t *testing.T) {
+	config := DefaultConfig()
+	config.SampleRate = 50
+	insightsLog := insightsLogger{config: config}
+
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		msg := logger.NewMessage()
+		msg.Line = []byte("Some Message")
+		envelope := insightsLog.createInsightsMessage(msg)
+		envelope.Tags[contracts.OperationId] = fmt.Sprintf("%016x%016x", rand.Int63(), rand.Int63())
+
+		decision := insightsLog.keep(envelope)
+		for j := 0; j < 5; j++ {
+			require.Equal(t, decision, insightsLog.keep(envelope))
+		}
+		if decision {
+			kept++
+		}
+	}
+	require.InDelta(t, 500, kept, 150)
+}
+
+func TestSamplingScore(t *testing.T) {
+	score := samplingScore("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.True(t, score >= 0 && score <= 100)
+	require.Equal(t, score, samplingScore("4bf92f3577b34da6a3ce929d0e0e4736"))
+	require.Equal(t, samplingScore("abcdabcd"), samplingScore("abcd"))
+}
@@ -13,24 +13,32 @@ import (
 )
 
 type insightsLogger struct {
-	client                *http.Client
-	transport             *http.Transport
-	url                   string
-	instrumentationKey    string
-	gzipCompression       bool
-	gzipCompressionLevel  int
-	postMessagesFrequency time.Duration
-	postMessagesBatchSize int
-	bufferMaximum         int
-	sendTimeout           time.Duration
+	// config shapes the telemetry created from log messages and never changes,
+	// settings is owned by the worker and swapped when the logger is reconfigured
+	config        Config
+	settings      *transportSettings
+	bufferMaximum int
+	sendTimeout   time.Duration
 	// For synchronization between background worker and logger.
 	// We use channel to send messages to worker go routine.
 	// All other variables for blocking Close call before we flush all messages to HEC
-	stream     chan *contracts.Envelope
-	lock       sync.RWMutex
-	closed     bool
-	closedCond *sync.Cond
-	logCtx     logger.Info
+	stream      chan *contracts.Envelope
+	reconfigure chan *transportSettings
+	lock        sync.RWMutex
+	closed      bool
+	closedCond  *sync.Cond
+	logCtx      logger.Info
+	// metadata holds the container metadata sent with every envelope. It is computed once
+	// and never modified, envelopes get a copy.
+	metadata map[string]string
+	// tags holds the context tags of the container, likewise computed once
+	tags       map[string]string
+	traces     stackTraces
+	metrics    metrics
+	prometheus prometheusState
+	sampler    adaptiveSampler
+	// redactor holds the redactions compiled from the config
+	redactor *redactor
 }
 
 func init() {
@@ -44,12 +52,60 @@ func init() {
 
 // New creates appinsights logger driver using configuration passed in context
 func New(info logger.Info) (logger.Logger, error) {
-	if err := InitializeEnv(info); err != nil {
+	return NewWithDefaults(info, DefaultConfig())
+}
+
+// NewWithDefaults creates appinsights logger driver using configuration passed in context,
+// falling back to defaults for every log opt that is not set
+func NewWithDefaults(info logger.Info, defaults Config) (logger.Logger, error) {
+	config, err := InitializeEnv(info, defaults)
+	if err != nil {
+		return nil, err
+	}
+	logrus.WithField("id", info.ContainerID).WithField("sources", config.Sources).Debug("Resolved logger configuration")
+
+	if err := VerifyConnection(config); err != nil {
+		return nil, err
+	}
+
+	settings, err := newTransportSettings(config)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := mapLogCtx(info, config)
+	if err != nil {
 		return nil, err
 	}
 
+	insightsLogger := &insightsLogger{
+		config:        config,
+		settings:      settings,
+		stream:        make(chan *contracts.Envelope, constants.StreamChannelSize),
+		reconfigure:   make(chan *transportSettings, 1),
+		bufferMaximum: constants.BufferMaximum,
+		sendTimeout:   constants.SendTimeout,
+		logCtx:        info,
+		metadata:      metadata,
+		tags:          contextTags(info, config),
+		redactor:      newRedactor(config),
+	}
+
+	go insightsLogger.worker()
+	return insightsLogger, nil
+}
+
+// transportSettings bundles everything the worker needs to send messages, so it can be swapped at once
+type transportSettings struct {
+	config    Config
+	client    *http.Client
+	transport *http.Transport
+	key       *instrumentationKey
+}
+
+func newTransportSettings(config Config) (*transportSettings, error) {
 	tlsConfig := &tls.Config{
-		InsecureSkipVerify: constants.InsecureSkipVerify,
+		InsecureSkipVerify: config.InsecureSkipVerify,
 	}
 
 	transport := &http.Transport{
@@ -60,30 +116,17 @@ func New(info logger.Info) (logger.Logger, error) {
 		Transport: transport,
 	}
 
-	if constants.VerifyConnection {
-		err := verifyInsightsConnection(constants.Endpoint)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	insightsLogger := &insightsLogger{
-		client:                client,
-		transport:             transport,
-		url:                   constants.Endpoint,
-		instrumentationKey:    constants.Token,
-		gzipCompression:       constants.GzipCompression,
-		gzipCompressionLevel:  constants.GzipCompressionLevel,
-		stream:                make(chan *contracts.Envelope, constants.StreamChannelSize),
-		postMessagesFrequency: constants.BatchInterval,
-		postMessagesBatchSize: constants.BatchSize,
-		bufferMaximum:         constants.BufferMaximum,
-		sendTimeout:           constants.SendTimeout,
-		logCtx:                info,
+	key, err := newInstrumentationKey(config)
+	if err != nil {
+		return nil, err
 	}
 
-	go insightsLogger.worker()
-	return insightsLogger, nil
+	return &transportSettings{
+		config:    config,
+		client:    client,
+		transport: transport,
+		key:       key,
+	}, nil
 }
 
 func (l *insightsLogger) Name() string {
@@ -91,7 +134,29 @@ func (l *insightsLogger) Name() string {
 }
 
 func (l *insightsLogger) Log(msg *logger.Message) error {
+	if l.extractMetrics(msg) {
+		logger.PutMessage(msg)
+		return nil
+	}
+	if l.config.Exceptions {
+		return l.logStackTrace(msg)
+	}
+
 	message := l.createInsightsMessage(msg)
 	logger.PutMessage(msg)
-	return l.queueMessageAsync(message)
+	return l.send(message)
+}
+
+// send queues the envelope, redacted and fitted to the ingestion limits, unless it is filtered out
+func (l *insightsLogger) send(message *contracts.Envelope) error {
+	if !l.keep(message) {
+		return nil
+	}
+	l.redact(message)
+	for _, envelope := range l.fitLimits(message) {
+		if err := l.queueMessageAsync(envelope); err != nil {
+			return err
+		}
+	}
+	return nil
 }
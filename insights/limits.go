@@ -0,0 +1,372 @@
+package insights
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+// How messages longer than the ingestion limit are sent
+const (
+	OversizeTruncate = "truncate"
+	OversizeSplit    = "split"
+)
+
+// Limits of the ingestion endpoint on a telemetry item
+const (
+	maxMessageLength       = 32768
+	maxPropertyKeyLength   = 150
+	maxPropertyValueLength = 8192
+	maxProperties          = 200
+	maxItemSize            = 64 * 1024
+)
+
+// truncatedMarker ends values cut to fit the limits
+const truncatedMarker = "...(truncated)"
+
+// Properties set on the parts of a split message
+const (
+	messageIDProperty   = "MessageId"
+	messagePartProperty = "MessagePart"
+)
+
+// Metrics counting the items changed to fit the limits
+var (
+	truncatedMetric = constants.DriverName + " truncated items"
+	splitMetric     = constants.DriverName + " split items"
+)
+
+// sanitizer is implemented by the telemetry contracts to cut fields to the documented limits
+type sanitizer interface {
+	Sanitize() []string
+}
+
+// fitLimits makes the envelope fit the limits of the ingestion endpoint. Oversize messages are either
+// truncated with a marker or split into sequenced envelopes, and properties are truncated or dropped.
+func (l *insightsLogger) fitLimits(envelope *ai.Envelope) []*ai.Envelope {
+	envelopes := []*ai.Envelope{envelope}
+	if message, ok := messageData(envelope); ok && len(message.Message) > maxMessageLength {
+		if l.config.Oversize == OversizeSplit {
+			envelopes = splitMessage(envelope, message)
+			l.metrics.add(splitMetric, nil, 1)
+		} else {
+			message.Message = truncate(message.Message, maxMessageLength)
+			l.metrics.add(truncatedMetric, nil, 1)
+		}
+	}
+
+	for _, envelope := range envelopes {
+		if l.fitProperties(envelope) {
+			l.metrics.add(truncatedMetric, nil, 1)
+		}
+	}
+	return envelopes
+}
+
+// fitProperties truncates the properties of an envelope and the envelope itself to the limits,
+// leaving the contracts to cut the remaining fields. It returns whether anything was changed.
+func (l *insightsLogger) fitProperties(envelope *ai.Envelope) bool {
+	data, ok := envelope.Data.(*ai.Data)
+	if !ok {
+		return false
+	}
+
+	changed := false
+	if properties := envelopeProperties(envelope); properties != nil {
+		changed = l.limitProperties(properties)
+		if fitItemSize(envelope, properties) {
+			changed = true
+		}
+	}
+
+	if base, ok := data.BaseData.(sanitizer); ok {
+		if warnings := base.Sanitize(); len(warnings) > 0 {
+			logrus.WithField("warnings", warnings).Debug("Truncated telemetry to the ingestion limits")
+			changed = true
+		}
+	}
+	return changed
+}
+
+// limitProperties truncates keys and values, and drops properties over the limit.
+// The container metadata is kept before the fields parsed from the line.
+func (l *insightsLogger) limitProperties(properties map[string]string) bool {
+	changed := false
+	var renamed []string
+	for key, val := range properties {
+		if len(val) > maxPropertyValueLength {
+			properties[key] = truncate(val, maxPropertyValueLength)
+			changed = true
+		}
+		if len(key) > maxPropertyKeyLength {
+			renamed = append(renamed, key)
+		}
+	}
+
+	// Keys are cut once the map is no longer iterated, in order so colliding keys are numbered the same way
+	sort.Strings(renamed)
+	for _, key := range renamed {
+		val := properties[key]
+		delete(properties, key)
+		properties[uniqueKey(properties, key)] = val
+		changed = true
+	}
+
+	if len(properties) <= maxProperties {
+		return changed
+	}
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		_, iMeta := l.metadata[keys[i]]
+		_, jMeta := l.metadata[keys[j]]
+		if iMeta != jMeta {
+			return iMeta
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys[maxProperties:] {
+		delete(properties, key)
+	}
+	return true
+}
+
+// fitItemSize cuts the largest of the message, exception stacks and property values until the serialized
+// envelope fits, then drops the measurements and parsed stack frames. It gives up once a pass no longer
+// shrinks the envelope, e.g. when the fields it cannot cut are over the limit on their own.
+func fitItemSize(envelope *ai.Envelope, properties map[string]string) bool {
+	texts := envelopeTexts(envelope)
+	measurements := envelopeMeasurements(envelope)
+	exceptions := envelopeExceptions(envelope)
+
+	// Serializing is only worth it when the item may come close to the limit
+	estimate := 0
+	for _, text := range texts {
+		estimate += len(*text)
+	}
+	for key, val := range properties {
+		estimate += len(key) + len(val)
+	}
+	for key := range measurements {
+		estimate += len(key) + 24
+	}
+	for _, exception := range exceptions {
+		estimate += len(exception.ParsedStack) * 64
+	}
+	if estimate < maxItemSize/4 {
+		return false
+	}
+
+	changed := false
+	previous := 0
+	for {
+		encoded, err := json.Marshal(envelope)
+		if err != nil || len(encoded) <= maxItemSize {
+			break
+		}
+		if previous > 0 && len(encoded) >= previous {
+			logrus.WithField("size", len(encoded)).Warn("Could not fit telemetry to the item size limit")
+			break
+		}
+		previous = len(encoded)
+		excess := len(encoded) - maxItemSize
+
+		var text *string
+		for _, candidate := range texts {
+			if text == nil || len(*candidate) > len(*text) {
+				text = candidate
+			}
+		}
+		largest, size := "", 0
+		for key, val := range properties {
+			if len(val) > size {
+				largest, size = key, len(val)
+			}
+		}
+
+		switch {
+		case text != nil && len(*text) > size && len(*text) > len(truncatedMarker):
+			*text = truncate(*text, len(*text)-excess)
+		case size > len(truncatedMarker):
+			properties[largest] = truncate(properties[largest], size-excess)
+		default:
+			// Nothing left to cut, the structured fields go as a whole
+			for name := range measurements {
+				delete(measurements, name)
+			}
+			for _, exception := range exceptions {
+				exception.ParsedStack = nil
+			}
+		}
+		changed = true
+	}
+
+	for _, exception := range exceptions {
+		if strings.HasSuffix(exception.Stack, truncatedMarker) {
+			exception.HasFullStack = false
+		}
+	}
+	return changed
+}
+
+// splitMessage splits an oversize message into envelopes of sequenced parts sharing a message id
+func splitMessage(envelope *ai.Envelope, message *ai.MessageData) []*ai.Envelope {
+	var parts []string
+	for text := message.Message; text != ""; {
+		end := len(text)
+		if end > maxMessageLength {
+			end = maxMessageLength
+			for end > 0 && !utf8.RuneStart(text[end]) {
+				end--
+			}
+		}
+		parts = append(parts, text[:end])
+		text = text[end:]
+	}
+
+	id := newRequestID()
+	envelopes := make([]*ai.Envelope, 0, len(parts))
+	for i, part := range parts {
+		properties := make(map[string]string, len(message.Properties)+2)
+		for key, val := range message.Properties {
+			properties[key] = val
+		}
+		properties[messageIDProperty] = id
+		properties[messagePartProperty] = fmt.Sprintf("%d/%d", i+1, len(parts))
+
+		partData := *message
+		partData.Message = part
+		partData.Properties = properties
+
+		// Each part gets its own maps, so fitting one part to the limits leaves the others alone
+		var baseData interface{} = &partData
+		if measured, ok := envelope.Data.(*ai.Data).BaseData.(*measuredMessageData); ok {
+			measurements := make(map[string]float64, len(measured.Measurements))
+			for key, val := range measured.Measurements {
+				measurements[key] = val
+			}
+			baseData = &measuredMessageData{MessageData: &partData, Measurements: measurements}
+		}
+		tags := make(map[string]string, len(envelope.Tags))
+		for key, val := range envelope.Tags {
+			tags[key] = val
+		}
+
+		partEnvelope := *envelope
+		partEnvelope.Tags = tags
+		partEnvelope.Seq = fmt.Sprintf("%s:%d", id, i+1)
+		partEnvelope.Data = &ai.Data{Base: ai.Base{BaseType: "MessageData"}, BaseData: baseData}
+		envelopes = append(envelopes, &partEnvelope)
+	}
+	return envelopes
+}
+
+// envelopeProperties returns the custom properties of the telemetry in an envelope
+func envelopeProperties(envelope *ai.Envelope) map[string]string {
+	if message, ok := messageData(envelope); ok {
+		return message.Properties
+	}
+	data, ok := envelope.Data.(*ai.Data)
+	if !ok {
+		return nil
+	}
+	switch base := data.BaseData.(type) {
+	case *ai.EventData:
+		return base.Properties
+	case *ai.ExceptionData:
+		return base.Properties
+	case *ai.RequestData:
+		return base.Properties
+	case *ai.MetricData:
+		return base.Properties
+	}
+	return nil
+}
+
+// envelopeTexts returns the message and exception texts of an envelope, which may be cut to fit the item size
+func envelopeTexts(envelope *ai.Envelope) []*string {
+	var texts []*string
+	if message, ok := messageData(envelope); ok {
+		texts = append(texts, &message.Message)
+	}
+	for _, exception := range envelopeExceptions(envelope) {
+		texts = append(texts, &exception.Message, &exception.Stack)
+	}
+	return texts
+}
+
+// envelopeExceptions returns the exceptions of exception telemetry
+func envelopeExceptions(envelope *ai.Envelope) []*ai.ExceptionDetails {
+	if data, ok := envelope.Data.(*ai.Data); ok {
+		if exception, ok := data.BaseData.(*ai.ExceptionData); ok {
+			return exception.Exceptions
+		}
+	}
+	return nil
+}
+
+// envelopeMeasurements returns the custom measurements of the telemetry in an envelope
+func envelopeMeasurements(envelope *ai.Envelope) map[string]float64 {
+	data, ok := envelope.Data.(*ai.Data)
+	if !ok {
+		return nil
+	}
+	switch base := data.BaseData.(type) {
+	case *measuredMessageData:
+		return base.Measurements
+	case *ai.EventData:
+		return base.Measurements
+	case *ai.ExceptionData:
+		return base.Measurements
+	case *ai.RequestData:
+		return base.Measurements
+	}
+	return nil
+}
+
+// truncate cuts val to at most max bytes, ending with the truncated marker, without splitting a character
+func truncate(val string, max int) string {
+	if len(val) <= max {
+		return val
+	}
+	end := max - len(truncatedMarker)
+	if end < 0 {
+		end = 0
+	}
+	for end > 0 && !utf8.RuneStart(val[end]) {
+		end--
+	}
+	return val[:end] + truncatedMarker
+}
+
+// truncateKey cuts a property key to at most max bytes without splitting a character
+func truncateKey(key string, max int) string {
+	if len(key) <= max {
+		return key
+	}
+	end := max
+	for end > 0 && !utf8.RuneStart(key[end]) {
+		end--
+	}
+	return key[:end]
+}
+
+// uniqueKey cuts a property key to the key length limit, numbering it when the cut key is already taken
+func uniqueKey(properties map[string]string, key string) string {
+	cut := truncateKey(key, maxPropertyKeyLength)
+	for i := 2; ; i++ {
+		if _, taken := properties[cut]; !taken {
+			return cut
+		}
+		suffix := fmt.Sprintf("_%d", i)
+		cut = truncateKey(key, maxPropertyKeyLength-len(suffix)) + suffix
+	}
+}
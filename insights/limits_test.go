@@ -0,0 +1,179 @@
+package insights
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+func fitLine(t *testing.T, oversize, line string, attrs map[string]string) (*insightsLogger, []*contracts.Envelope) {
+	insightsLog := newParsingLogger(t, map[string]string{constants.OversizeKey: oversize})
+	msg := logger.NewMessage()
+	msg.Line = []byte(line)
+	envelope := insightsLog.createInsightsMessage(msg)
+	message, _ := messageData(envelope)
+	for key, val := range attrs {
+		message.Properties[key] = val
+	}
+	return &insightsLog, insightsLog.fitLimits(envelope)
+}
+
+func countMetric(l *insightsLogger, name string) int {
+	for _, envelope := range l.metricEnvelopes() {
+		point := envelope.Data.(*contracts.Data).BaseData.(*contracts.MetricData).Metrics[0]
+		if point.Name == name {
+			return point.Count
+		}
+	}
+	return 0
+}
+
+func TestTruncateMessage(t *testing.T) {
+	l, envelopes := fitLine(t, OversizeTruncate, strings.Repeat("é", maxMessageLength), nil)
+	require.Len(t, envelopes, 1)
+
+	message, _ := messageData(envelopes[0])
+	require.True(t, len(message.Message) <= maxMessageLength)
+	require.True(t, strings.HasSuffix(message.Message, "é"+truncatedMarker))
+	require.Equal(t, 1, countMetric(l, truncatedMetric))
+}
+
+func TestSplitMessage(t *testing.T) {
+	line := strings.Repeat("a", maxMessageLength) + strings.Repeat("b", 10)
+	l, envelopes := fitLine(t, OversizeSplit, line, nil)
+	require.Len(t, envelopes, 2)
+
+	first, _ := messageData(envelopes[0])
+	second, _ := messageData(envelopes[1])
+	require.Equal(t, line, first.Message+second.Message)
+	require.Equal(t, "1/2", first.Properties[messagePartProperty])
+	require.Equal(t, "2/2", second.Properties[messagePartProperty])
+	require.Equal(t, first.Properties[messageIDProperty], second.Properties[messageIDProperty])
+	require.NotEqual(t, envelopes[0].Seq, envelopes[1].Seq)
+	require.Equal(t, 1, countMetric(l, splitMetric))
+	require.Equal(t, 0, countMetric(l, truncatedMetric))
+}
+
+func TestSplitMessageParts(t *testing.T) {
+	insightsLog := newParsingLogger(t, map[string]string{
+		constants.FormatKey:              FormatJSON,
+		constants.NumericMeasurementsKey: "true",
+	})
+	msg := logger.NewMessage()
+	msg.Line = []byte(`{"msg": "` + strings.Repeat("a", maxMessageLength+10) + `", "took": 12}`)
+	envelope := insightsLog.createInsightsMessage(msg)
+	message, _ := messageData(envelope)
+	envelopes := splitMessage(envelope, message)
+	require.Len(t, envelopes, 2)
+
+	// Changing one part leaves the tags and measurements of the other alone
+	envelopes[0].Tags["custom"] = "first"
+	delete(envelopeMeasurements(envelopes[0]), "took")
+	require.NotContains(t, envelopes[1].Tags, "custom")
+	require.Equal(t, map[string]float64{"took": 12}, envelopeMeasurements(envelopes[1]))
+}
+
+func TestLimitProperties(t *testing.T) {
+	attrs := map[string]string{
+		strings.Repeat("k", 200): "long key",
+		"long value":             strings.Repeat("v", maxPropertyValueLength+1),
+	}
+	for i := 0; i < maxProperties; i++ {
+		attrs[fmt.Sprintf("zz%03d", i)] = "value"
+	}
+
+	l, envelopes := fitLine(t, OversizeTruncate, "short line", attrs)
+	message, _ := messageData(envelopes[0])
+	require.Len(t, message.Properties, maxProperties)
+	require.Len(t, message.Properties["long value"], maxPropertyValueLength)
+	require.Equal(t, "long key", message.Properties[strings.Repeat("k", maxPropertyKeyLength)])
+	require.Equal(t, 1, countMetric(l, truncatedMetric))
+
+	encoded, err := json.Marshal(envelopes[0])
+	require.NoError(t, err)
+	require.True(t, len(encoded) <= maxItemSize)
+}
+
+func TestLimitPropertyKeyAndValue(t *testing.T) {
+	prefix := strings.Repeat("k", maxPropertyKeyLength)
+	_, envelopes := fitLine(t, OversizeTruncate, "short line", map[string]string{
+		prefix + "a": strings.Repeat("a", maxPropertyValueLength+1),
+		prefix + "b": "b",
+	})
+	message, _ := messageData(envelopes[0])
+
+	// Both keys are cut to the same prefix, the second one is numbered
+	require.Len(t, message.Properties[prefix], maxPropertyValueLength)
+	require.True(t, strings.HasSuffix(message.Properties[prefix], truncatedMarker))
+	numbered := strings.Repeat("k", maxPropertyKeyLength-2) + "_2"
+	require.Equal(t, "b", message.Properties[numbered])
+	for key := range message.Properties {
+		require.True(t, len(key) <= maxPropertyKeyLength, key)
+	}
+}
+
+func TestFitItemSize(t *testing.T) {
+	attrs := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		attrs[fmt.Sprintf("attr%d", i)] = strings.Repeat("v", maxPropertyValueLength)
+	}
+
+	_, envelopes := fitLine(t, OversizeTruncate, strings.Repeat("m", maxMessageLength), attrs)
+	encoded, err := json.Marshal(envelopes[0])
+	require.NoError(t, err)
+	require.True(t, len(encoded) <= maxItemSize)
+}
+
+func TestFitItemSizeException(t *testing.T) {
+	exception := newExceptionDetails(1, "TypeError", "boom")
+	exception.Stack = strings.Repeat("    at run (/app/index.js:3:9)\n", 4000)
+	for i := 0; i < 100; i++ {
+		addStackFrame(exception, "run", "", "/app/index.js", "3")
+	}
+	data := &contracts.ExceptionData{
+		Ver:          2,
+		Exceptions:   []*contracts.ExceptionDetails{exception},
+		Properties:   map[string]string{"Source": "stderr"},
+		Measurements: map[string]float64{"duration": 1},
+	}
+	envelope := (&insightsLogger{}).createEnvelope("ExceptionData", data, map[string]string{}, time.Now())
+
+	require.True(t, fitItemSize(envelope, data.Properties))
+	encoded, err := json.Marshal(envelope)
+	require.NoError(t, err)
+	require.True(t, len(encoded) <= maxItemSize)
+	require.True(t, strings.HasSuffix(exception.Stack, truncatedMarker))
+	require.False(t, exception.HasFullStack)
+}
+
+func TestFitItemSizeGivesUp(t *testing.T) {
+	insightsLog := newParsingLogger(t, map[string]string{})
+	msg := logger.NewMessage()
+	msg.Line = []byte(strings.Repeat("m", maxMessageLength))
+	envelope := insightsLog.createInsightsMessage(msg)
+	envelope.Tags["custom"] = strings.Repeat("t", maxItemSize)
+
+	// Tags are not cut, the envelope still shrinks as far as it can
+	message, _ := messageData(envelope)
+	require.True(t, fitItemSize(envelope, message.Properties))
+	require.Equal(t, truncatedMarker, message.Message)
+}
+
+func TestLimitMetricProperties(t *testing.T) {
+	insightsLog := newParsingLogger(t, map[string]string{constants.MetricFormatKey: MetricFormatStatsd})
+	require.True(t, insightsLog.parseStatsdLine("requests:1|c|#path:"+strings.Repeat("p", maxPropertyValueLength+10)))
+
+	envelopes := insightsLog.metricEnvelopes()
+	require.Len(t, envelopes, 1)
+	path := envelopeProperties(envelopes[0])["path"]
+	require.Len(t, path, maxPropertyValueLength)
+	require.True(t, strings.HasSuffix(path, truncatedMarker))
+	require.Equal(t, 1, countMetric(&insightsLog, truncatedMetric))
+}
@@ -1,12 +1,15 @@
 package insights
 
 import (
-	"testing"
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/api/types/backend"
 	"github.com/docker/docker/daemon/logger"
 	"github.com/stretchr/testify/require"
-	"github.com/docker/docker/api/types/backend"
 	"gitlab.com/michael.golfi/appinsights/constants"
-	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
 )
 
 func TestNewAppInsightsLogger(t *testing.T) {
@@ -49,12 +52,148 @@ func TestInflateTraceMessage(t *testing.T) {
 	}
 
 	trace := insightsLog.createInsightsMessage(msg)
-	data, ok := trace.Data.(contracts.Data)
+	data, ok := trace.Data.(*contracts.Data)
 	require.True(t, ok)
 
-	val, ok := data.BaseData.(contracts.MessageData)
+	val, ok := data.BaseData.(*contracts.MessageData)
 	require.True(t, ok)
 	require.Equal(t, string(msg.Line), val.Message)
 	require.Equal(t, msg.Source, val.Properties["Source"])
 	require.Equal(t, "World", val.Properties["Hello"])
 }
+
+// reconfigurable is the interface the handler reloads loggers through
+type reconfigurable interface {
+	Reconfigure(Config, *ConnectionCheck) error
+}
+
+func newRecordingServer() (*httptest.Server, *int32) {
+	var received int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	return server, &received
+}
+
+func TestReconfigureKeepsBufferedMessages(t *testing.T) {
+	first, firstReceived := newRecordingServer()
+	defer first.Close()
+	second, secondReceived := newRecordingServer()
+	defer second.Close()
+
+	defaults, err := MergeDefaults(DefaultConfig(), map[string]string{
+		constants.EndpointKey:         first.URL + "/v2/track",
+		constants.VerifyConnectionKey: "false",
+		constants.BatchIntervalKey:    "1h",
+	}, SourceFile)
+	require.NoError(t, err)
+
+	client, err := NewWithDefaults(logger.Info{
+		Config: map[string]string{
+			constants.TokenKey: "some token",
+		},
+	}, defaults)
+	require.NoError(t, err)
+
+	msg := logger.NewMessage()
+	msg.Line = []byte("Some Message")
+	require.NoError(t, client.Log(msg))
+
+	reloaded, err := MergeDefaults(defaults, map[string]string{
+		constants.EndpointKey: second.URL + "/v2/track",
+	}, SourceFile)
+	require.NoError(t, err)
+
+	rl, ok := client.(reconfigurable)
+	require.True(t, ok)
+	require.NoError(t, rl.Reconfigure(reloaded, NewConnectionCheck()))
+	require.NoError(t, client.Close())
+
+	require.Equal(t, int32(0), atomic.LoadInt32(firstReceived))
+	require.Equal(t, int32(1), atomic.LoadInt32(secondReceived))
+	require.Error(t, rl.Reconfigure(reloaded, NewConnectionCheck()))
+}
+
+func TestReconfigureKeepsLogOptOverrides(t *testing.T) {
+	first, firstReceived := newRecordingServer()
+	defer first.Close()
+	second, secondReceived := newRecordingServer()
+	defer second.Close()
+
+	client, err := NewWithDefaults(logger.Info{
+		Config: map[string]string{
+			constants.TokenKey:            "some token",
+			constants.EndpointKey:         first.URL + "/v2/track",
+			constants.VerifyConnectionKey: "false",
+		},
+	}, DefaultConfig())
+	require.NoError(t, err)
+
+	reloaded, err := MergeDefaults(DefaultConfig(), map[string]string{
+		constants.EndpointKey:  second.URL + "/v2/track",
+		constants.BatchSizeKey: "1",
+	}, SourceFile)
+	require.NoError(t, err)
+	require.NoError(t, client.(reconfigurable).Reconfigure(reloaded, NewConnectionCheck()))
+
+	// The new batch size applies, the endpoint set on the container does not change
+	msg := logger.NewMessage()
+	msg.Line = []byte("Some Message")
+	require.NoError(t, client.Log(msg))
+	require.NoError(t, client.Close())
+
+	require.Equal(t, int32(1), atomic.LoadInt32(firstReceived))
+	require.Equal(t, int32(0), atomic.LoadInt32(secondReceived))
+}
+
+func TestReconfigureReplacesPendingReload(t *testing.T) {
+	// Without a worker the first reload stays pending
+	insightsLog := &insightsLogger{
+		logCtx:      logger.Info{Config: map[string]string{constants.TokenKey: "some token"}},
+		reconfigure: make(chan *transportSettings, 1),
+	}
+
+	for _, size := range []string{"10", "20"} {
+		reloaded, err := MergeDefaults(DefaultConfig(), map[string]string{
+			constants.BatchSizeKey:        size,
+			constants.VerifyConnectionKey: "false",
+		}, SourceFile)
+		require.NoError(t, err)
+		require.NoError(t, insightsLog.Reconfigure(reloaded, NewConnectionCheck()))
+	}
+
+	settings := <-insightsLog.reconfigure
+	require.Equal(t, 20, settings.config.BatchSize)
+	require.Len(t, insightsLog.reconfigure, 0)
+}
+
+func TestReconfigureVerifiesLogOptEndpoint(t *testing.T) {
+	server, received := newRecordingServer()
+	defer server.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	defaults, err := MergeDefaults(DefaultConfig(), map[string]string{
+		constants.EndpointKey:         server.URL + "/v2/track",
+		constants.VerifyConnectionKey: "false",
+	}, SourceFile)
+	require.NoError(t, err)
+	client, err := NewWithDefaults(logger.Info{
+		Config: map[string]string{
+			constants.TokenKey:    "some token",
+			constants.EndpointKey: unreachable.URL + "/v2/track",
+		},
+	}, defaults)
+	require.NoError(t, err)
+	defer client.Close()
+
+	// The reloaded defaults verify connections, the endpoint set on the container cannot be reached
+	reloaded, err := MergeDefaults(defaults, map[string]string{constants.VerifyConnectionKey: "true"}, SourceFile)
+	require.NoError(t, err)
+	check := NewConnectionCheck()
+	require.NoError(t, check.Verify(reloaded))
+	require.NoError(t, check.Verify(reloaded))
+	require.Equal(t, int32(1), atomic.LoadInt32(received))
+	require.Error(t, client.(reconfigurable).Reconfigure(reloaded, check))
+}
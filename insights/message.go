@@ -1,79 +1,209 @@
 package insights
 
 import (
+	"strings"
 	"time"
 
 	"encoding/json"
-	"log"
 
 	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
 	"github.com/docker/docker/daemon/logger"
 )
 
 func (l *insightsLogger) createInsightsMessage(msg *logger.Message) *ai.Envelope {
-	ctx, err := mapLogCtx(l.logCtx)
-	if err != nil {
-		log.Println(err)
+	if request, ok := l.parseAccessLog(msg.Line); ok {
+		return l.createInsightsRequest(msg, request)
 	}
 
-	ctx["Source"] = msg.Source
-	for _, attr := range msg.Attrs {
-		ctx[attr.Key] = attr.Value
+	line := l.matchEvent(msg.Line, l.parseLine(msg.Line))
+	if line.Event != "" {
+		return l.createInsightsEvent(msg, line)
+	}
+
+	// Fields parsed from the line never replace the container metadata
+	ctx := l.messageProperties(msg)
+	for key, val := range line.Properties {
+		if _, ok := ctx[key]; !ok {
+			ctx[key] = val
+		}
+	}
+
+	data := &ai.MessageData{
+		Ver:           2,
+		Message:       line.Message,
+		SeverityLevel: l.resolveSeverity(msg, line.Level),
+		Properties:    ctx,
+	}
+
+	var baseData interface{} = data
+	if len(line.Measurements) > 0 {
+		baseData = &measuredMessageData{MessageData: data, Measurements: line.Measurements}
+	}
+
+	tags := l.createTags()
+	for tag, val := range l.operationTags(line.Properties) {
+		tags[tag] = val
+	}
+	return l.createEnvelope("MessageData", baseData, tags, l.envelopeTime(msg, line))
+}
+
+// createInsightsException creates exception telemetry from the lines of a stack trace.
+// The trace context and timestamp of the first line, when it is parsed, apply to the exception.
+func (l *insightsLogger) createInsightsException(msg *logger.Message, exceptions []*ai.ExceptionDetails, lines []string) *ai.Envelope {
+	exceptions[0].Stack = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	data := &ai.ExceptionData{
+		Ver:           2,
+		Exceptions:    exceptions,
+		SeverityLevel: ai.Error,
+		Properties:    l.messageProperties(msg),
+	}
+
+	line := l.parseLine(msg.Line)
+	tags := l.createTags()
+	for tag, val := range l.operationTags(line.Properties) {
+		tags[tag] = val
 	}
+	return l.createEnvelope("ExceptionData", data, tags, l.envelopeTime(msg, line))
+}
 
+// createEnvelope wraps telemetry of baseType in an envelope
+func (l *insightsLogger) createEnvelope(baseType string, baseData interface{}, tags map[string]string, timestamp time.Time) *ai.Envelope {
 	return &ai.Envelope{
-		Name:       "Microsoft.ApplicationInsights.MessageData",
-		IKey:       l.instrumentationKey,
-		SampleRate: 100.0,
-		Time:       time.Now().UTC().Format(time.RFC3339),
+		Name:       "Microsoft.ApplicationInsights." + baseType,
+		SampleRate: l.config.SampleRate,
+		Tags:       tags,
+		Time:       timestamp.UTC().Format(time.RFC3339Nano),
 		Data: &ai.Data{
 			Base: ai.Base{
-				BaseType: "MessageData",
-			},
-			BaseData: &ai.MessageData{
-				Ver:           2,
-				Message:       string(msg.Line),
-				SeverityLevel: ai.Verbose,
-				Properties:    ctx,
+				BaseType: baseType,
 			},
+			BaseData: baseData,
 		},
 	}
 }
 
-func mapLogCtx(logCtx logger.Info) (map[string]string, error) {
-	out := make(map[string]string, 5)
-	out["ContainerID"] = logCtx.ContainerID
-	out["ContainerName"] = logCtx.ContainerName
-	out["ContainerEntrypoint"] = logCtx.ContainerEntrypoint
-	out["ContainerImageID"] = logCtx.ContainerImageID
-	out["ContainerImageName"] = logCtx.ContainerImageName
-	out["LogPath"] = logCtx.LogPath
-	out["DaemonName"] = logCtx.DaemonName
-	out["ContainerCreated"] = logCtx.ContainerCreated.Format(time.RFC3339)
+// messageProperties returns a full copy of the container metadata with the attributes of a message.
+// Every message gets its own map because parsing, limits and redaction modify the properties in place,
+// the cache only saves rebuilding the metadata from the logger info for each line.
+func (l *insightsLogger) messageProperties(msg *logger.Message) map[string]string {
+	ctx := make(map[string]string, len(l.metadata)+len(msg.Attrs)+1)
+	for key, val := range l.metadata {
+		ctx[key] = val
+	}
 
-	args, err := json.Marshal(logCtx.ContainerArgs)
-	if err != nil {
-		return nil, err
+	ctx["Source"] = msg.Source
+	for _, attr := range msg.Attrs {
+		ctx[attr.Key] = attr.Value
 	}
+	return ctx
+}
+
+// measuredMessageData adds the measurements accepted by the ingestion endpoint for traces,
+// which the message contract does not carry
+type measuredMessageData struct {
+	*ai.MessageData
+	Measurements map[string]float64 `json:"measurements,omitempty"`
+}
 
-	env, err := json.Marshal(logCtx.ContainerEnv)
-	if err != nil {
-		return nil, err
+// messageData returns the message of message telemetry
+func messageData(envelope *ai.Envelope) (*ai.MessageData, bool) {
+	data, ok := envelope.Data.(*ai.Data)
+	if !ok {
+		return nil, false
 	}
+	switch message := data.BaseData.(type) {
+	case *ai.MessageData:
+		return message, true
+	case *measuredMessageData:
+		return message.MessageData, true
+	}
+	return nil, false
+}
 
-	conf, err := json.Marshal(logCtx.Config)
-	if err != nil {
-		return nil, err
+// envelopeTime returns when the container wrote the line. The timestamp parsed from
+// the line wins when configured, as Docker only records when the line was received.
+func (l *insightsLogger) envelopeTime(msg *logger.Message, line parsedLine) time.Time {
+	if l.config.LineTimestamp && !line.Timestamp.IsZero() {
+		return line.Timestamp
+	}
+	if !msg.Timestamp.IsZero() {
+		return msg.Timestamp
 	}
+	return time.Now()
+}
 
-	labels, err := json.Marshal(logCtx.ContainerLabels)
-	if err != nil {
-		return nil, err
+// contextFields are the container metadata fields that may be sent as properties
+var contextFields = []string{
+	"ContainerID", "ContainerName", "ContainerEntrypoint", "ContainerImageID", "ContainerImageName", "LogPath",
+	"DaemonName", "ContainerCreated", "ContainerArgs", "ContainerEnv", "Config", "ContainerLabels",
+}
+
+// mapLogCtx returns the container metadata fields selected by the include, exclude and env allowlist options.
+// The environment is only sent when included and then limited to the allowlisted variables, if any.
+func mapLogCtx(logCtx logger.Info, config Config) (map[string]string, error) {
+	fields := make(map[string]bool, len(contextFields))
+	for _, field := range config.IncludeFields {
+		fields[field] = true
+	}
+	if len(config.EnvAllowlist) > 0 {
+		fields["ContainerEnv"] = true
+	}
+	for _, field := range config.ExcludeFields {
+		delete(fields, field)
 	}
 
-	out["ContainerArgs"] = string(args)
-	out["ContainerEnv"] = string(env)
-	out["Config"] = string(conf)
-	out["ContainerLabels"] = string(labels)
+	out := make(map[string]string, len(fields))
+	for field := range fields {
+		var val interface{}
+		switch field {
+		case "ContainerID":
+			out[field] = logCtx.ContainerID
+		case "ContainerName":
+			out[field] = logCtx.ContainerName
+		case "ContainerEntrypoint":
+			out[field] = logCtx.ContainerEntrypoint
+		case "ContainerImageID":
+			out[field] = logCtx.ContainerImageID
+		case "ContainerImageName":
+			out[field] = logCtx.ContainerImageName
+		case "LogPath":
+			out[field] = logCtx.LogPath
+		case "DaemonName":
+			out[field] = logCtx.DaemonName
+		case "ContainerCreated":
+			out[field] = logCtx.ContainerCreated.Format(time.RFC3339)
+		case "ContainerArgs":
+			val = logCtx.ContainerArgs
+		case "ContainerEnv":
+			val = allowedEnv(logCtx.ContainerEnv, config.EnvAllowlist)
+		case "Config":
+			val = scrubOptions(logCtx.Config)
+		case "ContainerLabels":
+			val = logCtx.ContainerLabels
+		}
+		if val == nil {
+			continue
+		}
+
+		encoded, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		out[field] = string(encoded)
+	}
 	return out, nil
 }
+
+// allowedEnv returns the KEY=value entries of env whose key is in allowlist, or every entry without an allowlist
+func allowedEnv(env, allowlist []string) []string {
+	if len(allowlist) == 0 {
+		return env
+	}
+	allowed := []string{}
+	for _, entry := range env {
+		if containsString(allowlist, strings.SplitN(entry, "=", 2)[0]) {
+			allowed = append(allowed, entry)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,73 @@
+package insights
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/backend"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+func newBenchmarkLogger(b *testing.B) (*insightsLogger, *logger.Message) {
+	info := logger.Info{
+		Config:             map[string]string{constants.TokenKey: "some token", constants.EnvAllowlistKey: "VAR_1,VAR_2"},
+		ContainerID:        "5e3a1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8",
+		ContainerName:      "/project_web_1",
+		ContainerImageName: "registry:5000/team/web:1.2",
+		ContainerArgs:      []string{"--port", "8080", "--verbose"},
+		ContainerCreated:   time.Now(),
+		ContainerLabels:    map[string]string{},
+	}
+	for i := 0; i < 20; i++ {
+		info.ContainerEnv = append(info.ContainerEnv, fmt.Sprintf("VAR_%d=value %d", i, i))
+		info.ContainerLabels[fmt.Sprintf("com.example.label-%d", i)] = "value"
+	}
+
+	config, err := InitializeEnv(info, DefaultConfig())
+	require.NoError(b, err)
+	metadata, err := mapLogCtx(info, config)
+	require.NoError(b, err)
+
+	msg := logger.NewMessage()
+	msg.Source = "stdout"
+	msg.Attrs = []backend.LogAttr{{Key: "Hello", Value: "World"}}
+	return &insightsLogger{config: config, logCtx: info, metadata: metadata}, msg
+}
+
+// BenchmarkMessagePropertiesRebuilt serializes the container metadata for every message
+func BenchmarkMessagePropertiesRebuilt(b *testing.B) {
+	l, msg := newBenchmarkLogger(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx, _ := mapLogCtx(l.logCtx, l.config)
+		ctx["Source"] = msg.Source
+		for _, attr := range msg.Attrs {
+			ctx[attr.Key] = attr.Value
+		}
+	}
+}
+
+// BenchmarkMessagePropertiesCached copies the container metadata serialized when the logger was created
+func BenchmarkMessagePropertiesCached(b *testing.B) {
+	l, msg := newBenchmarkLogger(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.messageProperties(msg)
+	}
+}
+
+func TestMessagePropertiesCopy(t *testing.T) {
+	l := &insightsLogger{metadata: map[string]string{"ContainerName": "/web"}}
+	msg := logger.NewMessage()
+	msg.Source = "stderr"
+
+	ctx := l.messageProperties(msg)
+	ctx["ContainerName"] = "changed"
+	require.Equal(t, "stderr", ctx["Source"])
+	require.Equal(t, map[string]string{"ContainerName": "/web"}, l.metadata)
+}
@@ -0,0 +1,344 @@
+package insights
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+)
+
+// Formats of the metric lines
+const (
+	MetricFormatStatsd     = "statsd"
+	MetricFormatPrometheus = "prometheus"
+)
+
+// metricValueGroup is the group of a metric rule capturing the value to aggregate
+const metricValueGroup = "value"
+
+// MetricRule counts the lines matching Pattern, or aggregates the value group of Pattern, into the metric Name.
+// The other named groups of Pattern are the dimensions of the metric.
+type MetricRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// parseMetricRules parses rules such as errors=^ERROR;latency=took (?P<value>\d+)ms
+func parseMetricRules(val string) ([]MetricRule, error) {
+	var rules []MetricRule
+	for i, rule := range strings.Split(val, ";") {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+
+		pair := strings.SplitN(rule, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" || pair[1] == "" {
+			return nil, fmt.Errorf("rule %d must be of the form name=regex", i+1)
+		}
+
+		pattern, err := regexp.Compile(pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		rules = append(rules, MetricRule{Name: strings.TrimSpace(pair[0]), Pattern: pattern})
+	}
+	return rules, nil
+}
+
+// metricAggregate accumulates the samples of a metric over a batch interval
+type metricAggregate struct {
+	name       string
+	properties map[string]string
+	count      int
+	sum        float64
+	sumSquares float64
+	min        float64
+	max        float64
+}
+
+func (a *metricAggregate) add(value float64) {
+	if a.count == 0 || value < a.min {
+		a.min = value
+	}
+	if a.count == 0 || value > a.max {
+		a.max = value
+	}
+	a.count++
+	a.sum += value
+	a.sumSquares += value * value
+}
+
+func (a *metricAggregate) dataPoint() *ai.DataPoint {
+	mean := a.sum / float64(a.count)
+	return &ai.DataPoint{
+		Name:   a.name,
+		Kind:   ai.Aggregation,
+		Value:  a.sum,
+		Count:  a.count,
+		Min:    a.min,
+		Max:    a.max,
+		StdDev: math.Sqrt(math.Max(a.sumSquares/float64(a.count)-mean*mean, 0)),
+	}
+}
+
+// metrics holds the metrics extracted from the lines since the last flush of the worker
+type metrics struct {
+	lock       sync.Mutex
+	aggregates map[string]*metricAggregate
+}
+
+func (m *metrics) add(name string, properties map[string]string, value float64) {
+	id := metricID(name, properties)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.aggregates == nil {
+		m.aggregates = make(map[string]*metricAggregate)
+	}
+	aggregate, ok := m.aggregates[id]
+	if !ok {
+		aggregate = &metricAggregate{name: name, properties: properties}
+		m.aggregates[id] = aggregate
+	}
+	aggregate.add(value)
+}
+
+// metricID identifies the series of a metric by its name and dimensions
+func metricID(name string, properties map[string]string) string {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	id := name
+	for _, key := range keys {
+		id += "\x00" + key + "=" + properties[key]
+	}
+	return id
+}
+
+// drain returns the metrics aggregated so far and starts a new interval
+func (m *metrics) drain() []*metricAggregate {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	drained := make([]*metricAggregate, 0, len(m.aggregates))
+	for _, aggregate := range m.aggregates {
+		drained = append(drained, aggregate)
+	}
+	m.aggregates = nil
+	return drained
+}
+
+// extractMetrics records the metrics of a line. Lines in one of the metric formats are
+// consumed as metrics, other lines are counted by the metric rules and still logged.
+func (l *insightsLogger) extractMetrics(msg *logger.Message) bool {
+	line := strings.TrimSpace(string(msg.Line))
+	for _, format := range l.config.MetricFormats {
+		var ok bool
+		switch format {
+		case MetricFormatStatsd:
+			ok = l.parseStatsdLine(line)
+		case MetricFormatPrometheus:
+			ok = l.parsePrometheusLine(line)
+		}
+		if ok {
+			return true
+		}
+	}
+
+	for _, rule := range l.config.MetricRules {
+		match := rule.Pattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		value := 1.0
+		properties := make(map[string]string)
+		for i, name := range rule.Pattern.SubexpNames() {
+			switch {
+			case name == "" || i == 0:
+			case name == metricValueGroup:
+				value, _ = strconv.ParseFloat(match[i], 64)
+			default:
+				properties[name] = match[i]
+			}
+		}
+		l.metrics.add(rule.Name, properties, value)
+	}
+	return false
+}
+
+// statsdLine matches name:value|type[|@rate][|#tags] with the counter, gauge, timer and histogram types
+var statsdLine = regexp.MustCompile(`^([^:|\s]+):(-?[0-9.eE+-]+)\|(c|g|ms|h|d)(?:\|@([0-9.]+))?(?:\|#(.*))?$`)
+
+func (l *insightsLogger) parseStatsdLine(line string) bool {
+	match := statsdLine.FindStringSubmatch(line)
+	if match == nil {
+		return false
+	}
+	value, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return false
+	}
+
+	// Sampled counters are scaled back up
+	if rate, err := strconv.ParseFloat(match[4], 64); err == nil && rate > 0 && match[3] == "c" {
+		value /= rate
+	}
+
+	properties := make(map[string]string)
+	for _, tag := range strings.Split(match[5], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			pair := strings.SplitN(tag, ":", 2)
+			properties[pair[0]] = ""
+			if len(pair) == 2 {
+				properties[pair[0]] = pair[1]
+			}
+		}
+	}
+	l.metrics.add(match[1], properties, value)
+	return true
+}
+
+// The Prometheus text exposition format. A sample is only read as a metric once a # TYPE line declared its
+// family, so lines that merely look like a name followed by a number are still logged.
+var (
+	prometheusLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)` +
+		`(?:\{((?:[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*")(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*")*,?)?\})?` +
+		`[ \t]+([-+]?(?:[0-9]+\.?[0-9]*|\.[0-9]+)(?:[eE][-+]?[0-9]+)?|NaN|[-+]?Inf)(?:[ \t]+-?[0-9]+)?$`)
+	prometheusLabel = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\.)*)"`)
+	prometheusHelp  = regexp.MustCompile(`^# HELP [a-zA-Z_:][a-zA-Z0-9_:]* `)
+	prometheusType  = regexp.MustCompile(`^# TYPE ([a-zA-Z_:][a-zA-Z0-9_:]*) (counter|gauge|histogram|summary|untyped)$`)
+)
+
+// prometheusSuffixes name the samples of a family, such as the buckets of a histogram
+var prometheusSuffixes = []string{"_total", "_bucket", "_count", "_sum"}
+
+// prometheusState remembers the declared families and the last value of each cumulative series
+type prometheusState struct {
+	lock     sync.Mutex
+	types    map[string]string
+	counters map[string]float64
+}
+
+// familyType returns the declared type of the family of a sample
+func (p *prometheusState) familyType(name string) (string, bool) {
+	if kind, ok := p.types[name]; ok {
+		return kind, true
+	}
+	for _, suffix := range prometheusSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			if kind, ok := p.types[strings.TrimSuffix(name, suffix)]; ok {
+				return kind, true
+			}
+		}
+	}
+	return "", false
+}
+
+// cumulative reports whether the samples named name only ever grow: counters, and the buckets, counts and sums
+// of histograms and summaries. The quantiles of summaries are point in time values, like gauges.
+func (p *prometheusState) cumulative(name, kind string) bool {
+	switch kind {
+	case "counter":
+		return true
+	case "histogram", "summary":
+		for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+			if strings.HasSuffix(name, suffix) && p.types[strings.TrimSuffix(name, suffix)] == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// delta returns the increase of a cumulative series since its previous sample. The first sample only
+// records where the series starts, and a value below the previous one means the counter was reset.
+func (p *prometheusState) delta(id string, value float64) (float64, bool) {
+	previous, ok := p.counters[id]
+	p.counters[id] = value
+	if !ok {
+		return 0, false
+	}
+	if value < previous {
+		return value, true
+	}
+	return value - previous, true
+}
+
+func (l *insightsLogger) parsePrometheusLine(line string) bool {
+	l.prometheus.lock.Lock()
+	defer l.prometheus.lock.Unlock()
+	if l.prometheus.types == nil {
+		l.prometheus.types = make(map[string]string)
+		l.prometheus.counters = make(map[string]float64)
+	}
+
+	if match := prometheusType.FindStringSubmatch(line); match != nil {
+		l.prometheus.types[match[1]] = match[2]
+		return true
+	}
+	if prometheusHelp.MatchString(line) {
+		return true
+	}
+
+	match := prometheusLine.FindStringSubmatch(line)
+	if match == nil {
+		return false
+	}
+	kind, ok := l.prometheus.familyType(match[1])
+	if !ok {
+		return false
+	}
+	// Samples without a finite value are part of the exposition but cannot be aggregated
+	value, err := strconv.ParseFloat(match[3], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return true
+	}
+
+	properties := make(map[string]string)
+	for _, label := range prometheusLabel.FindAllStringSubmatch(match[2], -1) {
+		if val, err := strconv.Unquote(`"` + label[2] + `"`); err == nil {
+			properties[label[1]] = val
+		} else {
+			properties[label[1]] = label[2]
+		}
+	}
+
+	// Cumulative samples send their increase instead of the running total
+	if l.prometheus.cumulative(match[1], kind) {
+		if value, ok = l.prometheus.delta(metricID(match[1], properties), value); !ok {
+			return true
+		}
+	}
+	l.metrics.add(match[1], properties, value)
+	return true
+}
+
+// metricEnvelopes creates metric telemetry from the metrics aggregated since the last call.
+// Metrics are already aggregated so they are never sampled.
+func (l *insightsLogger) metricEnvelopes() []*ai.Envelope {
+	var envelopes []*ai.Envelope
+	now := time.Now()
+	for _, aggregate := range l.metrics.drain() {
+		data := &ai.MetricData{
+			Ver:        2,
+			Metrics:    []*ai.DataPoint{aggregate.dataPoint()},
+			Properties: aggregate.properties,
+		}
+		envelope := l.createEnvelope("MetricData", data, l.createTags(), now)
+		envelope.SampleRate = 100
+		// Dimensions come from the lines, so they are redacted and held to the same limits as other properties
+		l.redact(envelope)
+		envelopes = append(envelopes, l.fitLimits(envelope)...)
+	}
+	return envelopes
+}
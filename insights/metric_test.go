@@ -0,0 +1,124 @@
+package insights
+
+import (
+	"testing"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+// logMetrics logs the lines and returns the metrics sent on the next flush, keyed by name
+func logMetrics(t *testing.T, opts map[string]string, lines ...string) map[string]*contracts.MetricData {
+	opts[constants.TokenKey] = "some token"
+	opts[constants.ExceptionsKey] = "false"
+	config, err := InitializeEnv(logger.Info{Config: opts}, DefaultConfig())
+	require.NoError(t, err)
+	insightsLog := &insightsLogger{config: config, stream: make(chan *contracts.Envelope, 10)}
+
+	for _, line := range lines {
+		msg := logger.NewMessage()
+		msg.Line = []byte(line)
+		require.NoError(t, insightsLog.Log(msg))
+	}
+
+	metrics := make(map[string]*contracts.MetricData)
+	for _, envelope := range insightsLog.metricEnvelopes() {
+		require.Equal(t, "Microsoft.ApplicationInsights.MetricData", envelope.Name)
+		require.Equal(t, 100.0, envelope.SampleRate)
+		data := envelope.Data.(*contracts.Data).BaseData.(*contracts.MetricData)
+		metrics[data.Metrics[0].Name] = data
+	}
+	require.Empty(t, insightsLog.metricEnvelopes())
+	return metrics
+}
+
+func TestStatsdMetrics(t *testing.T) {
+	metrics := logMetrics(t, map[string]string{constants.MetricFormatKey: MetricFormatStatsd},
+		"requests:1|c|@0.5|#route:/api,method:GET",
+		"requests:1|c|@0.5|#method:GET,route:/api",
+		"latency:20|ms",
+		"latency:40|ms",
+		"not a metric")
+
+	requests := metrics["requests"]
+	require.Equal(t, map[string]string{"route": "/api", "method": "GET"}, requests.Properties)
+	require.Equal(t, 4.0, requests.Metrics[0].Value)
+	require.Equal(t, 2, requests.Metrics[0].Count)
+
+	latency := metrics["latency"].Metrics[0]
+	require.Equal(t, contracts.Aggregation, latency.Kind)
+	require.Equal(t, 60.0, latency.Value)
+	require.Equal(t, 2, latency.Count)
+	require.Equal(t, 20.0, latency.Min)
+	require.Equal(t, 40.0, latency.Max)
+	require.Equal(t, 10.0, latency.StdDev)
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	opts := map[string]string{constants.MetricFormatKey: MetricFormatPrometheus}
+	metrics := logMetrics(t, opts,
+		"# HELP http_requests_total The total number of requests.",
+		"# TYPE http_requests_total counter",
+		`http_requests_total{method="post",path="/a \"b\""} 1027 1395066363000`,
+		`http_requests_total{method="post",path="/a \"b\""} 1030 1395066364000`,
+		`http_requests_total{method="post",path="/a \"b\""} 1040 1395066365000`,
+		"# TYPE go_goroutines gauge",
+		"go_goroutines 12",
+		"go_goroutines NaN")
+
+	// Counters send their increase, the first sample only sets where the series starts
+	require.Len(t, metrics, 2)
+	require.Equal(t, map[string]string{"method": "post", "path": `/a "b"`}, metrics["http_requests_total"].Properties)
+	require.Equal(t, 13.0, metrics["http_requests_total"].Metrics[0].Value)
+	require.Equal(t, 2, metrics["http_requests_total"].Metrics[0].Count)
+	require.Equal(t, 12.0, metrics["go_goroutines"].Metrics[0].Value)
+	require.Equal(t, 1, metrics["go_goroutines"].Metrics[0].Count)
+
+	// A counter reset counts from zero again
+	metrics = logMetrics(t, opts, "# TYPE jobs counter", "jobs_total 10", "jobs_total 15", "jobs_total 4")
+	require.Equal(t, 9.0, metrics["jobs_total"].Metrics[0].Value)
+
+	// Quantiles of a summary are sent as they are, its count increases
+	metrics = logMetrics(t, opts,
+		"# TYPE rpc_duration_seconds summary",
+		`rpc_duration_seconds{quantile="0.5"} 0.25`,
+		`rpc_duration_seconds{quantile="0.5"} 0.125`,
+		"rpc_duration_seconds_count 100",
+		"rpc_duration_seconds_count 130")
+	require.Equal(t, 0.375, metrics["rpc_duration_seconds"].Metrics[0].Value)
+	require.Equal(t, 2, metrics["rpc_duration_seconds"].Metrics[0].Count)
+	require.Equal(t, 0.125, metrics["rpc_duration_seconds"].Metrics[0].Min)
+	require.Equal(t, 30.0, metrics["rpc_duration_seconds_count"].Metrics[0].Value)
+}
+
+func TestPrometheusRequiresType(t *testing.T) {
+	insightsLog := newParsingLogger(t, map[string]string{constants.MetricFormatKey: MetricFormatPrometheus})
+	require.False(t, insightsLog.parsePrometheusLine("queue_depth 1"))
+	require.True(t, insightsLog.parsePrometheusLine("# TYPE queue_depth gauge"))
+
+	for _, line := range []string{
+		"Listening 8080",
+		"queue_depth{name=unquoted} 1",
+		"queue_depth 1 2 3",
+		"# just a comment",
+	} {
+		require.False(t, insightsLog.parsePrometheusLine(line), line)
+	}
+	require.True(t, insightsLog.parsePrometheusLine(`queue_depth{name="jobs",} 1`))
+}
+
+func TestMetricRules(t *testing.T) {
+	metrics := logMetrics(t, map[string]string{
+		constants.MetricRulesKey: `errors=^ERROR;query_ms=query on (?P<table>\w+) took (?P<value>[\d.]+)ms`,
+	}, "ERROR disk full", "ERROR disk full", "query on users took 12.5ms", "query on users took 7.5ms")
+
+	require.Equal(t, 2.0, metrics["errors"].Metrics[0].Value)
+	require.Equal(t, map[string]string{"table": "users"}, metrics["query_ms"].Properties)
+	require.Equal(t, 20.0, metrics["query_ms"].Metrics[0].Value)
+	require.Equal(t, 2, metrics["query_ms"].Metrics[0].Count)
+
+	_, err := parseMetricRules("errors")
+	require.Error(t, err)
+}
@@ -0,0 +1,540 @@
+package insights
+
+import (
+	"compress/gzip"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+// Types of option values
+const (
+	TypeString   = "string"
+	TypeURL      = "url"
+	TypeBool     = "bool"
+	TypeInt      = "int"
+	TypeFloat    = "float"
+	TypeDuration = "duration"
+	TypeSeverity = "severity"
+	TypeRules    = "rules"
+	TypeRates    = "rates"
+	TypeList     = "list"
+	TypePattern  = "pattern"
+	TypePatterns = "patterns"
+)
+
+// Option describes a single log opt. The registry of options drives validation,
+// the command line flags and the documentation of the plugin.
+type Option struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Default string `json:"default"`
+	Min     string `json:"min,omitempty"`
+	Max     string `json:"max,omitempty"`
+	// Values lists the accepted values of options limited to a fixed set
+	Values []string `json:"values,omitempty"`
+	// Groups lists the named groups a pattern option must have
+	Groups      []string `json:"groups,omitempty"`
+	Description string   `json:"description"`
+	// Secret options are never emitted or logged
+	Secret bool `json:"secret,omitempty"`
+	// Label options may be overridden by container labels prefixed with constants.LabelPrefix
+	Label     bool   `json:"label,omitempty"`
+	Shorthand string `json:"-"`
+
+	// field returns a pointer to the Config field holding the value
+	field func(c *Config) interface{}
+}
+
+// Options is the registry of every log opt supported by the plugin
+var Options = []Option{
+	{
+		Name:        constants.EndpointKey,
+		Type:        TypeURL,
+		Default:     constants.Endpoint,
+		Description: "The URL for App Insights",
+		field:       func(c *Config) interface{} { return &c.Endpoint },
+	},
+	{
+		Name:        constants.TokenKey,
+		Type:        TypeString,
+		Default:     constants.Token,
+		Description: "Insights Instrumentation Key",
+		Secret:      true,
+		Shorthand:   "k",
+		field:       func(c *Config) interface{} { return &c.Token },
+	},
+	{
+		Name:        constants.ConnectionStringKey,
+		Type:        TypeString,
+		Default:     constants.ConnectionString,
+		Description: "App Insights connection string, replaces the endpoint and token",
+		Secret:      true,
+		field:       func(c *Config) interface{} { return &c.ConnectionString },
+	},
+	{
+		Name:        constants.TokenFileKey,
+		Type:        TypeString,
+		Default:     constants.TokenFile,
+		Description: "File holding the Insights Instrumentation Key, re-read when it changes",
+		field:       func(c *Config) interface{} { return &c.TokenFile },
+	},
+	{
+		Name:        constants.VerifyConnectionKey,
+		Type:        TypeBool,
+		Default:     strconv.FormatBool(constants.VerifyConnection),
+		Description: "Verify the connection to App Insights on start",
+		field:       func(c *Config) interface{} { return &c.VerifyConnection },
+	},
+	{
+		Name:        constants.InsecureSkipVerifyKey,
+		Type:        TypeBool,
+		Default:     strconv.FormatBool(constants.InsecureSkipVerify),
+		Description: "Skip verifying the SSL certificate",
+		field:       func(c *Config) interface{} { return &c.InsecureSkipVerify },
+	},
+	{
+		Name:        constants.GzipCompressionKey,
+		Type:        TypeBool,
+		Default:     strconv.FormatBool(constants.GzipCompression),
+		Description: "Enable GZip compression",
+		Shorthand:   "c",
+		field:       func(c *Config) interface{} { return &c.GzipCompression },
+	},
+	{
+		Name:        constants.GzipCompressionLevelKey,
+		Type:        TypeInt,
+		Default:     strconv.Itoa(constants.GzipCompressionLevel),
+		Min:         strconv.Itoa(gzip.HuffmanOnly),
+		Max:         strconv.Itoa(gzip.BestCompression),
+		Description: "GZip compression level",
+		field:       func(c *Config) interface{} { return &c.GzipCompressionLevel },
+	},
+	{
+		Name:        constants.BatchSizeKey,
+		Type:        TypeInt,
+		Default:     strconv.Itoa(constants.BatchSize),
+		Min:         "1",
+		Max:         strconv.Itoa(math.MaxInt32),
+		Description: "Message Batch Size",
+		field:       func(c *Config) interface{} { return &c.BatchSize },
+	},
+	{
+		Name:        constants.BatchIntervalKey,
+		Type:        TypeDuration,
+		Default:     constants.BatchInterval.String(),
+		Min:         time.Millisecond.String(),
+		Description: "Message Batch Interval",
+		field:       func(c *Config) interface{} { return &c.BatchInterval },
+	},
+	{
+		Name:        constants.RoleKey,
+		Type:        TypeString,
+		Default:     constants.Role,
+		Description: "Cloud role name of the container in App Insights",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.Role },
+	},
+	{
+		Name:        constants.MinSeverityKey,
+		Type:        TypeSeverity,
+		Default:     constants.MinSeverity,
+		Description: "Messages below this severity are dropped",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.MinSeverity },
+	},
+	{
+		Name:        constants.SampleRateKey,
+		Type:        TypeFloat,
+		Default:     strconv.FormatFloat(constants.SampleRate, 'f', -1, 64),
+		Min:         "0",
+		Max:         "100",
+		Description: "Percentage of telemetry sent to App Insights",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.SampleRate },
+	},
+	{
+		Name:        constants.SeveritySampleRateKey,
+		Type:        TypeRates,
+		Default:     constants.SeveritySampleRate,
+		Description: "Comma separated `severity=rate` percentages of messages and exceptions sent, replacing sample-rate for those severities",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.SeveritySampleRates },
+	},
+	{
+		Name:        constants.MaxItemsPerSecondKey,
+		Type:        TypeFloat,
+		Default:     strconv.FormatFloat(constants.MaxItemsPerSecond, 'f', -1, 64),
+		Min:         "0",
+		Description: "Target of telemetry items per second, sampling adapts to stay under it when above 0",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.MaxItemsPerSecond },
+	},
+	{
+		Name:        constants.SamplingFloorKey,
+		Type:        TypeSeverity,
+		Default:     constants.SamplingFloor,
+		Description: "Messages and exceptions at or above this severity are never dropped by adaptive sampling",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.SamplingFloor },
+	},
+	{
+		Name:        constants.StdoutSeverityKey,
+		Type:        TypeSeverity,
+		Default:     constants.StdoutSeverity,
+		Description: "Severity of stdout lines not matched by a rule or level field",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.StdoutSeverity },
+	},
+	{
+		Name:        constants.StderrSeverityKey,
+		Type:        TypeSeverity,
+		Default:     constants.StderrSeverity,
+		Description: "Severity of stderr lines not matched by a rule or level field",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.StderrSeverity },
+	},
+	{
+		Name:        constants.SeverityRulesKey,
+		Type:        TypeRules,
+		Default:     constants.SeverityRules,
+		Description: "Semicolon separated `severity=regex` rules, the first rule matching a line sets its severity",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.SeverityRules },
+	},
+	{
+		Name:        constants.SeverityFieldKey,
+		Type:        TypeList,
+		Default:     constants.SeverityField,
+		Description: "Comma separated fields holding the severity of structured lines, takes precedence over the rules",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.SeverityFields },
+	},
+	{
+		Name:        constants.FormatKey,
+		Type:        TypeString,
+		Default:     constants.Format,
+		Values:      []string{FormatText, FormatJSON, FormatLogfmt},
+		Description: "Format of the log lines, structured lines are parsed into the message and its properties",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.Format },
+	},
+	{
+		Name:        constants.MessageFieldKey,
+		Type:        TypeList,
+		Default:     constants.MessageField,
+		Description: "Comma separated fields holding the message of structured lines",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.MessageFields },
+	},
+	{
+		Name:        constants.TimestampFieldKey,
+		Type:        TypeList,
+		Default:     constants.TimestampField,
+		Description: "Comma separated fields holding the timestamp of structured lines",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.TimestampFields },
+	},
+	{
+		Name:        constants.NumericMeasurementsKey,
+		Type:        TypeBool,
+		Default:     strconv.FormatBool(constants.NumericMeasurements),
+		Description: "Send numeric fields of structured lines as measurements instead of properties",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.NumericMeasurements },
+	},
+	{
+		Name:        constants.ParsePatternKey,
+		Type:        TypePattern,
+		Default:     constants.ParsePattern,
+		Description: "Regex with named groups or built-in pattern parsing text lines, the groups message, level and timestamp are mapped onto the telemetry",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.ParsePattern },
+	},
+	{
+		Name:        constants.LineTimestampKey,
+		Type:        TypeBool,
+		Default:     strconv.FormatBool(constants.LineTimestamp),
+		Description: "Use the timestamp parsed from the line instead of the time Docker received it",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.LineTimestamp },
+	},
+	{
+		Name:        constants.RoleSourceKey,
+		Type:        TypeList,
+		Default:     constants.RoleSource,
+		Values:      []string{RoleSourceComposeService, RoleSourceSwarmService, RoleSourceContainerName, RoleSourceImageName},
+		Description: "Comma separated sources of the cloud role when role is not set, the first source with a value wins",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.RoleSources },
+	},
+	{
+		Name:        constants.RoleInstanceSourceKey,
+		Type:        TypeString,
+		Default:     constants.RoleInstanceSource,
+		Values:      []string{RoleSourceContainerID, RoleSourceContainerName, RoleSourceHostname},
+		Description: "Source of the cloud role instance",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.RoleInstanceSource },
+	},
+	{
+		Name:        constants.TraceparentFieldKey,
+		Type:        TypeList,
+		Default:     constants.TraceparentField,
+		Description: "Comma separated fields holding a W3C traceparent, sets the operation id and parent id",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.TraceparentFields },
+	},
+	{
+		Name:        constants.OperationIDFieldKey,
+		Type:        TypeList,
+		Default:     constants.OperationIDField,
+		Description: "Comma separated fields holding the operation id when there is no traceparent",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.OperationIDFields },
+	},
+	{
+		Name:        constants.OperationParentFieldKey,
+		Type:        TypeList,
+		Default:     constants.OperationParentField,
+		Description: "Comma separated fields holding the operation parent id when there is no traceparent",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.OperationParentFields },
+	},
+	{
+		Name:        constants.ExceptionsKey,
+		Type:        TypeBool,
+		Default:     strconv.FormatBool(constants.Exceptions),
+		Description: "Send Go, Java, .NET, Python and Node stack traces as exceptions",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.Exceptions },
+	},
+	{
+		Name:        constants.ExceptionTimeoutKey,
+		Type:        TypeDuration,
+		Default:     constants.ExceptionTimeout.String(),
+		Min:         time.Millisecond.String(),
+		Description: "Time to wait for the next line of a stack trace before it is sent",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.ExceptionTimeout },
+	},
+	{
+		Name:        constants.EventFieldKey,
+		Type:        TypeList,
+		Default:     constants.EventField,
+		Description: "Comma separated fields of structured lines holding the name of a custom event",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.EventFields },
+	},
+	{
+		Name:        constants.EventPrefixKey,
+		Type:        TypeString,
+		Default:     constants.EventPrefix,
+		Description: "Prefix of lines sent as custom events, followed by the event name and JSON or key=value properties",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.EventPrefix },
+	},
+	{
+		Name:        constants.EventPatternKey,
+		Type:        TypePattern,
+		Default:     constants.EventPattern,
+		Groups:      []string{eventGroup},
+		Description: "Regex of lines sent as custom events, the group event names the event and the other groups are its properties",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.EventPattern },
+	},
+	{
+		Name:        constants.MetricFormatKey,
+		Type:        TypeList,
+		Default:     constants.MetricFormat,
+		Values:      []string{MetricFormatStatsd, MetricFormatPrometheus},
+		Description: "Comma separated formats of lines sent as metrics instead of messages, aggregated over the batch interval",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.MetricFormats },
+	},
+	{
+		Name:        constants.MetricRulesKey,
+		Type:        TypeRules,
+		Default:     constants.MetricRules,
+		Description: "Semicolon separated `metric=regex` rules counting the matching lines, or summing the group value, over the batch interval",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.MetricRules },
+	},
+	{
+		Name:        constants.AccessLogKey,
+		Type:        TypeList,
+		Default:     constants.AccessLog,
+		Values:      []string{AccessLogCommon, AccessLogCombined, AccessLogJSON},
+		Description: "Comma separated formats of access log lines sent as requests instead of messages",
+		Label:       true,
+		field:       func(c *Config) interface{} { return &c.AccessLogs },
+	},
+	{
+		Name:        constants.IncludeFieldsKey,
+		Type:        TypeList,
+		Default:     constants.IncludeFields,
+		Values:      contextFields,
+		Description: "Comma separated container metadata fields sent as properties, the environment and log opts are left out by default",
+		field:       func(c *Config) interface{} { return &c.IncludeFields },
+	},
+	{
+		Name:        constants.ExcludeFieldsKey,
+		Type:        TypeList,
+		Default:     constants.ExcludeFields,
+		Values:      contextFields,
+		Description: "Comma separated container metadata fields never sent, takes precedence over include-fields",
+		field:       func(c *Config) interface{} { return &c.ExcludeFields },
+	},
+	{
+		Name:        constants.EnvAllowlistKey,
+		Type:        TypeList,
+		Default:     constants.EnvAllowlist,
+		Description: "Comma separated environment variables sent in ContainerEnv, which is then included unless excluded",
+		field:       func(c *Config) interface{} { return &c.EnvAllowlist },
+	},
+	{
+		Name:        constants.OversizeKey,
+		Type:        TypeString,
+		Default:     constants.Oversize,
+		Values:      []string{OversizeTruncate, OversizeSplit},
+		Description: "Whether messages over the ingestion limit are truncated with a marker or split into sequenced parts",
+		field:       func(c *Config) interface{} { return &c.Oversize },
+	},
+	{
+		Name:        constants.RedactKey,
+		Type:        TypeList,
+		Default:     constants.Redact,
+		Values:      redactionDetectorNames,
+		Description: "Comma separated built-in detectors of secrets and personal data redacted from messages and properties",
+		field:       func(c *Config) interface{} { return &c.Redact },
+	},
+	{
+		Name:        constants.RedactPatternsKey,
+		Type:        TypePatterns,
+		Default:     constants.RedactPatterns,
+		Description: "Semicolon separated regexes redacted from messages and properties, limited to the group secret if present",
+		field:       func(c *Config) interface{} { return &c.RedactPatterns },
+	},
+	{
+		Name:        constants.RedactModeKey,
+		Type:        TypeString,
+		Default:     constants.RedactMode,
+		Values:      []string{RedactMask, RedactHash, RedactDrop},
+		Description: "Whether redacted values are masked, replaced by a hash or dropped with their property",
+		field:       func(c *Config) interface{} { return &c.RedactMode },
+	},
+	{
+		Name:        constants.RedactLocalKey,
+		Type:        TypeBool,
+		Default:     strconv.FormatBool(constants.RedactLocal),
+		Description: "Also redact the lines written to the local json file read by docker logs",
+		field:       func(c *Config) interface{} { return &c.RedactLocal },
+	},
+	{
+		Name:        constants.RedactHashKeyKey,
+		Type:        TypeString,
+		Default:     constants.RedactHashKey,
+		Description: "Key of the HMAC replacing values redacted with redact-mode=hash, a random key per plugin start when empty",
+		Secret:      true,
+		field:       func(c *Config) interface{} { return &c.RedactHashKey },
+	},
+}
+
+// LookupOption returns the option registered under name
+func LookupOption(name string) (Option, bool) {
+	for _, opt := range Options {
+		if opt.Name == name {
+			return opt, true
+		}
+	}
+	return Option{}, false
+}
+
+// Range describes the valid values of the option, if limited
+func (o Option) Range() string {
+	switch {
+	case len(o.Values) > 0:
+		return strings.Join(o.Values, ", ")
+	case o.Min != "" && o.Max != "":
+		return fmt.Sprintf("%s to %s", o.Min, o.Max)
+	case o.Min != "":
+		return fmt.Sprintf(">= %s", o.Min)
+	case o.Max != "":
+		return fmt.Sprintf("<= %s", o.Max)
+	case o.Type == TypeBool:
+		return "true, false"
+	case o.Type == TypeSeverity:
+		return "verbose, information, warning, error, critical"
+	case o.Type == TypePattern && len(o.Groups) > 0:
+		return "regex with the groups " + strings.Join(o.Groups, ", ")
+	case o.Type == TypePattern:
+		return "regex, " + strings.Join(builtinPatternNames(), ", ")
+	}
+	return ""
+}
+
+// apply parses the value of the option in info, if set, into config
+func (o Option) apply(info logger.Info, config *Config) error {
+	var err error
+	switch field := o.field(config).(type) {
+	case *string:
+		switch {
+		case o.Type == TypeURL:
+			*field, err = getAdvancedOptionURL(info, o.Name, *field)
+		case len(o.Values) > 0:
+			*field, err = getAdvancedOptionEnum(info, o.Name, *field, o.Values)
+		default:
+			*field = getAdvancedOption(info, o.Name, *field)
+		}
+	case *bool:
+		*field, err = getAdvancedOptionBool(info, o.Name, *field)
+	case *int:
+		min, max := math.MinInt32, math.MaxInt32
+		if o.Min != "" {
+			min, _ = strconv.Atoi(o.Min)
+		}
+		if o.Max != "" {
+			max, _ = strconv.Atoi(o.Max)
+		}
+		*field, err = getAdvancedOptionInt(info, o.Name, *field, min, max)
+	case *float64:
+		min, max := -math.MaxFloat64, math.MaxFloat64
+		if o.Min != "" {
+			min, _ = strconv.ParseFloat(o.Min, 64)
+		}
+		if o.Max != "" {
+			max, _ = strconv.ParseFloat(o.Max, 64)
+		}
+		*field, err = getAdvancedOptionFloat(info, o.Name, *field, min, max)
+	case *time.Duration:
+		var min time.Duration
+		if o.Min != "" {
+			min, _ = time.ParseDuration(o.Min)
+		}
+		*field, err = getAdvancedOptionDuration(info, o.Name, *field, min)
+	case *ai.SeverityLevel:
+		*field, err = getAdvancedOptionSeverity(info, o.Name, *field)
+	case *[]string:
+		*field, err = getAdvancedOptionList(info, o.Name, *field, o.Values)
+	case **regexp.Regexp:
+		*field, err = getAdvancedOptionPattern(info, o.Name, *field, o.Groups)
+	case *[]SeverityRule:
+		*field, err = getAdvancedOptionSeverityRules(info, o.Name, *field)
+	case *map[ai.SeverityLevel]float64:
+		*field, err = getAdvancedOptionSeverityRates(info, o.Name, *field)
+	case *[]*regexp.Regexp:
+		*field, err = getAdvancedOptionPatterns(info, o.Name, *field)
+	case *[]MetricRule:
+		*field, err = getAdvancedOptionMetricRules(info, o.Name, *field)
+	default:
+		err = fmt.Errorf("unsupported option type %T", field)
+	}
+	return err
+}
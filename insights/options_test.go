@@ -0,0 +1,51 @@
+package insights
+
+import (
+	"testing"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+func TestOptionsRegistry(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, opt := range Options {
+		require.False(t, seen[opt.Name], "duplicate option %s", opt.Name)
+		seen[opt.Name] = true
+		require.NotEmpty(t, opt.Description, opt.Name)
+		require.NotNil(t, opt.field, opt.Name)
+
+		found, ok := LookupOption(opt.Name)
+		require.True(t, ok)
+		require.Equal(t, opt.Name, found.Name)
+	}
+
+	_, ok := LookupOption("unknown")
+	require.False(t, ok)
+}
+
+func TestDefaultConfigFromRegistry(t *testing.T) {
+	config := DefaultConfig()
+	require.Equal(t, constants.Endpoint, config.Endpoint)
+	require.Equal(t, constants.VerifyConnection, config.VerifyConnection)
+	require.Equal(t, constants.BatchSize, config.BatchSize)
+	require.Equal(t, constants.BatchInterval, config.BatchInterval)
+	require.Equal(t, contracts.Verbose, config.MinSeverity)
+	require.Equal(t, constants.SampleRate, config.SampleRate)
+	require.Empty(t, config.Sources)
+}
+
+func TestOptionRange(t *testing.T) {
+	gzipLevel, _ := LookupOption(constants.GzipCompressionLevelKey)
+	require.Equal(t, "-2 to 9", gzipLevel.Range())
+
+	interval, _ := LookupOption(constants.BatchIntervalKey)
+	require.Equal(t, ">= 1ms", interval.Range())
+
+	gzip, _ := LookupOption(constants.GzipCompressionKey)
+	require.Equal(t, "true, false", gzip.Range())
+
+	role, _ := LookupOption(constants.RoleKey)
+	require.Empty(t, role.Range())
+}
@@ -0,0 +1,316 @@
+package insights
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of the log lines
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// parsedLine holds the parts of a log line mapped onto the envelope
+type parsedLine struct {
+	Event        string
+	Message      string
+	Level        string
+	Timestamp    time.Time
+	Properties   map[string]string
+	Measurements map[string]float64
+}
+
+// fieldNames lists the candidate fields holding the event name, message, level and timestamp of a line
+type fieldNames struct {
+	event     []string
+	message   []string
+	level     []string
+	timestamp []string
+}
+
+// parseLine splits a log line according to the configured format.
+// Lines that cannot be parsed are sent unchanged as the message.
+func (l *insightsLogger) parseLine(line []byte) parsedLine {
+	switch l.config.Format {
+	case FormatJSON:
+		if parsed, err := l.parseJSONLine(line); err == nil {
+			return parsed
+		}
+	case FormatLogfmt:
+		if parsed, err := l.parseLogfmtLine(line); err == nil {
+			return parsed
+		}
+	}
+
+	if l.config.ParsePattern != nil {
+		if parsed, err := l.parsePatternLine(line); err == nil {
+			return parsed
+		}
+	}
+
+	parsed := parsedLine{Message: string(line)}
+	parsed.Level, _ = structuredLevel(line, l.config.SeverityFields)
+	return parsed
+}
+
+// parseJSONLine parses a JSON object. Nested objects are flattened with dotted keys.
+func (l *insightsLogger) parseJSONLine(line []byte) (parsedLine, error) {
+	fields, err := decodeJSONFields(line)
+	if err != nil {
+		return parsedLine{}, err
+	}
+	return l.mapFields(fields, string(line), l.fieldNames()), nil
+}
+
+// parseLogfmtLine parses key=value pairs such as level=info msg="started" port=8080.
+func (l *insightsLogger) parseLogfmtLine(line []byte) (parsedLine, error) {
+	fields, err := decodeLogfmtFields(line)
+	if err != nil {
+		return parsedLine{}, err
+	}
+	return l.mapFields(fields, string(line), l.fieldNames()), nil
+}
+
+// decodeJSONFields decodes a JSON object, flattening nested objects with dotted keys
+func decodeJSONFields(line []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	flat := make(map[string]interface{}, len(fields))
+	flatten("", fields, flat)
+	return flat, nil
+}
+
+// decodeLogfmtFields decodes key=value pairs. Keys without a value are set to true as in logfmt.
+func decodeLogfmtFields(line []byte) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	pairs := 0
+	rest := strings.TrimSpace(string(line))
+	for rest != "" {
+		end := strings.IndexAny(rest, "= ")
+		if end == 0 {
+			return nil, fmt.Errorf("expected a key at %q", rest)
+		}
+		if end < 0 {
+			end = len(rest)
+		}
+
+		key := rest[:end]
+		rest = rest[end:]
+		if !strings.HasPrefix(rest, "=") {
+			fields[key] = "true"
+			rest = strings.TrimLeft(rest, " ")
+			continue
+		}
+
+		val, remaining, quoted, err := logfmtValue(rest[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %v", key, err)
+		}
+		rest = strings.TrimLeft(remaining, " ")
+		pairs++
+
+		// Bare numbers are kept as numbers so they may be sent as measurements
+		if finiteNumber(val) && !quoted {
+			fields[key] = json.Number(val)
+		} else {
+			fields[key] = val
+		}
+	}
+
+	if pairs == 0 {
+		return nil, fmt.Errorf("no key=value pairs")
+	}
+	return fields, nil
+}
+
+// finiteNumber reports whether val is a number that may be sent as a measurement.
+// NaN and infinities are kept as text, they cannot be serialized to JSON.
+func finiteNumber(val string) bool {
+	f, err := strconv.ParseFloat(val, 64)
+	return err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// logfmtValue reads a bare or double quoted value from the start of s and returns the rest of s
+func logfmtValue(s string) (string, string, bool, error) {
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			end = len(s)
+		}
+		if strings.ContainsRune(s[:end], '"') {
+			return "", "", false, fmt.Errorf("unexpected quote")
+		}
+		return s[:end], s[end:], false, nil
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			val, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", true, err
+			}
+			if i+1 < len(s) && s[i+1] != ' ' {
+				return "", "", true, fmt.Errorf("expected a space after the closing quote")
+			}
+			return val, s[i+1:], true, nil
+		}
+	}
+	return "", "", true, fmt.Errorf("unterminated quote")
+}
+
+// fieldNames returns the configured fields of structured lines
+func (l *insightsLogger) fieldNames() fieldNames {
+	return fieldNames{
+		event:     l.config.EventFields,
+		message:   l.config.MessageFields,
+		level:     l.config.SeverityFields,
+		timestamp: l.config.TimestampFields,
+	}
+}
+
+// mapFields moves the message, level and timestamp fields onto the envelope
+// and every other field into the properties or measurements.
+// Lines naming an event keep their message as a property and send every number as a measurement.
+func (l *insightsLogger) mapFields(fields map[string]interface{}, raw string, names fieldNames) parsedLine {
+	parsed := parsedLine{
+		Message:      raw,
+		Properties:   make(map[string]string, len(fields)),
+		Measurements: make(map[string]float64),
+	}
+
+	if key, ok := firstField(fields, names.event); ok {
+		parsed.Event = formatField(fields[key])
+		delete(fields, key)
+	}
+	if key, ok := firstField(fields, names.message); ok && parsed.Event == "" {
+		parsed.Message = formatField(fields[key])
+		delete(fields, key)
+	}
+	if key, ok := firstField(fields, names.level); ok {
+		parsed.Level = formatField(fields[key])
+		delete(fields, key)
+	}
+	if key, ok := firstField(fields, names.timestamp); ok {
+		// The timestamp field is kept as a property unless it sets the time of the envelope
+		if ts, ok := parseTimestamp(formatField(fields[key])); ok {
+			parsed.Timestamp = ts
+			if l.config.LineTimestamp {
+				delete(fields, key)
+			}
+		}
+	}
+
+	for key, val := range fields {
+		if number, ok := val.(json.Number); ok && (l.config.NumericMeasurements || parsed.Event != "") {
+			if f, err := number.Float64(); err == nil {
+				parsed.Measurements[key] = f
+				continue
+			}
+		}
+		parsed.Properties[key] = formatField(val)
+	}
+	return parsed
+}
+
+// flatten copies nested objects of fields into out with dotted keys
+func flatten(prefix string, fields map[string]interface{}, out map[string]interface{}) {
+	for key, val := range fields {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := val.(map[string]interface{}); ok {
+			flatten(key, nested, out)
+			continue
+		}
+		out[key] = val
+	}
+}
+
+// firstField returns the first of names present in fields
+func firstField(fields map[string]interface{}, names []string) (string, bool) {
+	for _, name := range names {
+		if _, ok := fields[name]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// formatField renders a field value as a property. Arrays are kept as JSON.
+func formatField(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	}
+}
+
+// timestampLayouts are the layouts of the timestamps written by common servers.
+// Layouts without a year are completed with the current year.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"02/Jan/2006:15:04:05 -0700",
+	time.Stamp,
+	"0102 15:04:05.999999",
+}
+
+// epochScales are the units of unix epochs, from seconds to nanoseconds
+var epochScales = []float64{1, 1e3, 1e6, 1e9}
+
+// minEpoch and maxEpoch bound the plausible epochs in seconds, from 2000 to 2100
+const (
+	minEpoch = 946684800
+	maxEpoch = 4102444800
+)
+
+// parseTimestamp accepts the timestamp layouts above and unix epochs. The unit of an epoch is inferred from its
+// magnitude, epochs that are not plausible in any unit are rejected so the time Docker received the line is used.
+func parseTimestamp(val string) (time.Time, bool) {
+	for _, layout := range timestampLayouts {
+		if ts, err := time.Parse(layout, val); err == nil {
+			if ts.Year() == 0 {
+				ts = ts.AddDate(time.Now().Year(), 0, 0)
+			}
+			return ts, true
+		}
+	}
+
+	epoch, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, scale := range epochScales {
+		if sec := epoch / scale; sec >= minEpoch && sec < maxEpoch {
+			whole := int64(sec)
+			return time.Unix(whole, int64((sec-float64(whole))*1e9)), true
+		}
+	}
+	return time.Time{}, false
+}
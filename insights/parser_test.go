@@ -0,0 +1,253 @@
+package insights
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+func newParsingLogger(t *testing.T, opts map[string]string) insightsLogger {
+	opts[constants.TokenKey] = "some token"
+	config, err := InitializeEnv(logger.Info{Config: opts}, DefaultConfig())
+	require.NoError(t, err)
+	return insightsLogger{config: config, redactor: newRedactor(config)}
+}
+
+func TestParseJSONLine(t *testing.T) {
+	insightsLog := newParsingLogger(t, map[string]string{
+		constants.FormatKey:        FormatJSON,
+		constants.LineTimestampKey: "true",
+	})
+
+	line := insightsLog.parseLine([]byte(`{"msg":"started","level":"warn","time":"2018-03-01T10:00:00.123Z","port":8080,"http":{"method":"GET","tls":true},"tags":["a","b"]}`))
+	require.Equal(t, "started", line.Message)
+	require.Equal(t, "warn", line.Level)
+	require.Equal(t, time.Date(2018, 3, 1, 10, 0, 0, 123000000, time.UTC), line.Timestamp.UTC())
+	require.Equal(t, map[string]string{
+		"port":        "8080",
+		"http.method": "GET",
+		"http.tls":    "true",
+		"tags":        `["a","b"]`,
+	}, line.Properties)
+	require.Empty(t, line.Measurements)
+}
+
+func TestParseJSONLineMeasurements(t *testing.T) {
+	insightsLog := newParsingLogger(t, map[string]string{
+		constants.FormatKey:              FormatJSON,
+		constants.NumericMeasurementsKey: "true",
+		constants.MessageFieldKey:        "text",
+	})
+
+	msg := logger.NewMessage()
+	msg.Line = []byte(`{"text":"request served","duration":12.5,"user":"bob"}`)
+	envelope := insightsLog.createInsightsMessage(msg)
+
+	data, ok := messageData(envelope)
+	require.True(t, ok)
+	require.Equal(t, "request served", data.Message)
+	require.Equal(t, "bob", data.Properties["user"])
+
+	encoded, err := json.Marshal(envelope.Data.(*contracts.Data).BaseData)
+	require.NoError(t, err)
+	require.Contains(t, string(encoded), `"measurements":{"duration":12.5}`)
+	require.Contains(t, string(encoded), `"message":"request served"`)
+}
+
+func TestParseJSONLineFallback(t *testing.T) {
+	insightsLog := newParsingLogger(t, map[string]string{constants.FormatKey: FormatJSON})
+
+	line := insightsLog.parseLine([]byte("not json"))
+	require.Equal(t, "not json", line.Message)
+	require.Empty(t, line.Properties)
+
+	_, err := InitializeEnv(logger.Info{Config: map[string]string{
+		constants.TokenKey:  "some token",
+		constants.FormatKey: "xml",
+	}}, DefaultConfig())
+	require.Error(t, err)
+}
+
+func TestParseTimestamp(t *testing.T) {
+	expected := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, val := range []string{"2018-03-01T10:00:00Z", "1519898400", "1519898400000", "1519898400000000", "1519898400000000000"} {
+		ts, ok := parseTimestamp(val)
+		require.True(t, ok, val)
+		require.True(t, expected.Equal(ts), val)
+	}
+
+	ts, ok := parseTimestamp("1519898400.5")
+	require.True(t, ok)
+	require.True(t, expected.Add(500*time.Millisecond).Equal(ts))
+
+	// Values that are not plausible epochs in any unit fall back to the time of the entry
+	for _, val := range []string{"yesterday", "5", "-1519898400", "15198984000"} {
+		_, ok := parseTimestamp(val)
+		require.False(t, ok, val)
+	}
+}
+
+func TestParseLogfmtLine(t *testing.T) {
+	insightsLog := newParsingLogger(t, map[string]string{
+		constants.FormatKey:              FormatLogfmt,
+		constants.NumericMeasurementsKey: "true",
+		constants.LineTimestampKey:       "true",
+	})
+
+	line := insightsLog.parseLine([]byte(`level=error msg="failed to \"connect\"" time=2018-03-01T10:00:00Z port=8080 addr=":8080" retry`))
+	require.Equal(t, `failed to "connect"`, line.Message)
+	require.Equal(t, "error", line.Level)
+	require.Equal(t, time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC), line.Timestamp.UTC())
+	require.Equal(t, map[string]string{"addr": ":8080", "retry": "true"}, line.Properties)
+	require.Equal(t, map[string]float64{"port": 8080}, line.Measurements)
+
+	line = insightsLog.parseLine([]byte(`msg=slow latency=NaN wait=-Inf took=1.5`))
+	require.Equal(t, map[string]string{"latency": "NaN", "wait": "-Inf"}, line.Properties)
+	require.Equal(t, map[string]float64{"took": 1.5}, line.Measurements)
+}
+
+func TestParseLogfmtLineFallback(t *testing.T) {
+	insightsLog := newParsingLogger(t, map[string]string{constants.FormatKey: FormatLogfmt})
+
+	for _, raw := range []string{
+		"server started",
+		`msg="unterminated`,
+		`msg="no"space`,
+		`=value`,
+	} {
+		line := insightsLog.parseLine([]byte(raw))
+		require.Equal(t, raw, line.Message)
+		require.Empty(t, line.Properties, raw)
+	}
+}
+
+func TestParsePatternBuiltin(t *testing.T) {
+	year := time.Now().Year()
+	cases := []struct {
+		pattern    string
+		line       string
+		message    string
+		level      string
+		timestamp  time.Time
+		properties map[string]string
+	}{
+		{
+			pattern:   "nginx-combined",
+			line:      `172.17.0.1 - - [01/Mar/2018:10:00:00 +0000] "GET /index.html HTTP/1.1" 200 612 "-" "curl/7.54.0"`,
+			message:   `172.17.0.1 - - [01/Mar/2018:10:00:00 +0000] "GET /index.html HTTP/1.1" 200 612 "-" "curl/7.54.0"`,
+			timestamp: time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC),
+			properties: map[string]string{
+				"remote_addr":     "172.17.0.1",
+				"remote_user":     "-",
+				"request":         "GET /index.html HTTP/1.1",
+				"status":          "200",
+				"body_bytes_sent": "612",
+				"http_referer":    "-",
+				"http_user_agent": "curl/7.54.0",
+			},
+		},
+		{
+			pattern:   "apache-common",
+			line:      `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			message:   `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			timestamp: time.Date(2000, 10, 10, 20, 55, 36, 0, time.UTC),
+			properties: map[string]string{
+				"remote_addr": "127.0.0.1",
+				"ident":       "-",
+				"remote_user": "frank",
+				"request":     "GET /apache_pb.gif HTTP/1.0",
+				"status":      "200",
+				"bytes":       "2326",
+			},
+		},
+		{
+			pattern:    "syslog",
+			line:       `<34>Oct 11 22:14:15 mymachine su[230]: 'su root' failed for lonvick on /dev/pts/8`,
+			message:    `'su root' failed for lonvick on /dev/pts/8`,
+			timestamp:  time.Date(year, 10, 11, 22, 14, 15, 0, time.UTC),
+			properties: map[string]string{"priority": "34", "hostname": "mymachine", "program": "su", "pid": "230"},
+		},
+		{
+			pattern:    "klog",
+			line:       `E0301 10:00:00.123456       1 controller.go:114] error syncing "default/web"`,
+			message:    `error syncing "default/web"`,
+			level:      "E",
+			timestamp:  time.Date(year, 3, 1, 10, 0, 0, 123456000, time.UTC),
+			properties: map[string]string{"thread": "1", "file": "controller.go", "line": "114"},
+		},
+	}
+
+	for _, c := range cases {
+		insightsLog := newParsingLogger(t, map[string]string{
+			constants.ParsePatternKey:  c.pattern,
+			constants.LineTimestampKey: "true",
+		})
+		line := insightsLog.parseLine([]byte(c.line))
+		require.Equal(t, c.message, line.Message, c.pattern)
+		require.Equal(t, c.level, line.Level, c.pattern)
+		require.True(t, c.timestamp.Equal(line.Timestamp), c.pattern)
+		require.Equal(t, c.properties, line.Properties, c.pattern)
+	}
+}
+
+func TestParsePatternCustom(t *testing.T) {
+	insightsLog := newParsingLogger(t, map[string]string{
+		constants.ParsePatternKey: `^\[(?P<level>\w+)\] (?P<thread>\S+) - (?P<message>.*)$`,
+	})
+
+	line := insightsLog.parseLine([]byte("[WARN] main - disk almost full"))
+	require.Equal(t, "disk almost full", line.Message)
+	require.Equal(t, "WARN", line.Level)
+	require.Equal(t, map[string]string{"thread": "main"}, line.Properties)
+
+	line = insightsLog.parseLine([]byte("unmatched line"))
+	require.Equal(t, "unmatched line", line.Message)
+	require.Empty(t, line.Properties)
+
+	insightsLog = newParsingLogger(t, map[string]string{
+		constants.ParsePatternKey:        `^(?P<message>\w+) in (?P<ms>\S+)$`,
+		constants.NumericMeasurementsKey: "true",
+	})
+	line = insightsLog.parseLine([]byte("done in NaN"))
+	require.Equal(t, map[string]string{"ms": "NaN"}, line.Properties)
+	require.Empty(t, line.Measurements)
+
+	for _, invalid := range []string{"[", "^no groups$"} {
+		_, err := InitializeEnv(logger.Info{Config: map[string]string{
+			constants.TokenKey:        "some token",
+			constants.ParsePatternKey: invalid,
+		}}, DefaultConfig())
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestEnvelopeTime(t *testing.T) {
+	written := time.Date(2018, 3, 1, 10, 0, 0, 123456789, time.UTC)
+	msg := logger.NewMessage()
+	msg.Timestamp = written
+	msg.Line = []byte(`{"msg":"started","time":"2018-03-01T09:59:59Z"}`)
+
+	insightsLog := newParsingLogger(t, map[string]string{constants.FormatKey: FormatJSON})
+	envelope := insightsLog.createInsightsMessage(msg)
+	require.Equal(t, "2018-03-01T10:00:00.123456789Z", envelope.Time)
+
+	data, ok := messageData(envelope)
+	require.True(t, ok)
+	require.Equal(t, "2018-03-01T09:59:59Z", data.Properties["time"])
+
+	insightsLog = newParsingLogger(t, map[string]string{
+		constants.FormatKey:        FormatJSON,
+		constants.LineTimestampKey: "true",
+	})
+	envelope = insightsLog.createInsightsMessage(msg)
+	require.Equal(t, "2018-03-01T09:59:59Z", envelope.Time)
+
+	data, ok = messageData(envelope)
+	require.True(t, ok)
+	require.NotContains(t, data.Properties, "time")
+}
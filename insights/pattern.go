@@ -0,0 +1,76 @@
+package insights
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// builtinPatterns are the parse patterns that may be referred to by name
+var builtinPatterns = map[string]string{
+	"nginx-combined": `^(?P<remote_addr>\S+) \S+ (?P<remote_user>\S+) \[(?P<timestamp>[^\]]+)\] "(?P<request>[^"]*)" (?P<status>\d{3}) (?P<body_bytes_sent>\d+|-) "(?P<http_referer>[^"]*)" "(?P<http_user_agent>[^"]*)"`,
+	"apache-common":  `^(?P<remote_addr>\S+) (?P<ident>\S+) (?P<remote_user>\S+) \[(?P<timestamp>[^\]]+)\] "(?P<request>[^"]*)" (?P<status>\d{3}) (?P<bytes>\d+|-)`,
+	"syslog":         `^(?:<(?P<priority>\d+)>)?(?P<timestamp>[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}) (?P<hostname>\S+) (?P<program>[^:\[\s]+)(?:\[(?P<pid>\d+)\])?: (?P<message>.*)$`,
+	"klog":           `^(?P<level>[IWEF])(?P<timestamp>\d{4} \d{2}:\d{2}:\d{2}\.\d{6})\s+(?P<thread>\d+) (?P<file>[^:\s]+):(?P<line>\d+)\] (?P<message>.*)$`,
+}
+
+// patternFieldNames are the reserved group names mapped onto the envelope
+var patternFieldNames = fieldNames{
+	message:   []string{"message"},
+	level:     []string{"level"},
+	timestamp: []string{"timestamp"},
+}
+
+// builtinPatternNames returns the sorted names of the built-in patterns
+func builtinPatternNames() []string {
+	names := make([]string, 0, len(builtinPatterns))
+	for name := range builtinPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parsePattern compiles the name of a built-in pattern or a regex with named groups
+func parsePattern(val string) (*regexp.Regexp, error) {
+	if builtin, ok := builtinPatterns[val]; ok {
+		val = builtin
+	}
+
+	pattern, err := regexp.Compile(val)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range pattern.SubexpNames() {
+		if name != "" {
+			return pattern, nil
+		}
+	}
+	return nil, fmt.Errorf("expected a regex with named groups or one of the built-in patterns")
+}
+
+// parsePatternLine captures the named groups of the parse pattern. The groups
+// named message, level and timestamp are mapped onto the envelope.
+func (l *insightsLogger) parsePatternLine(line []byte) (parsedLine, error) {
+	match := l.config.ParsePattern.FindSubmatch(line)
+	if match == nil {
+		return parsedLine{}, fmt.Errorf("line does not match the parse pattern")
+	}
+
+	fields := make(map[string]interface{}, len(match))
+	for i, name := range l.config.ParsePattern.SubexpNames() {
+		if name == "" || match[i] == nil {
+			continue
+		}
+
+		// Captured numbers are kept as numbers so they may be sent as measurements
+		val := string(match[i])
+		if finiteNumber(val) {
+			fields[name] = json.Number(val)
+		} else {
+			fields[name] = val
+		}
+	}
+	return l.mapFields(fields, string(line), patternFieldNames), nil
+}
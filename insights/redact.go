@@ -0,0 +1,238 @@
+package insights
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+// How redacted values are replaced
+const (
+	RedactMask = "mask"
+	RedactHash = "hash"
+	RedactDrop = "drop"
+)
+
+// redactSecretGroup limits the redaction to a group of the match, keeping e.g. the name of a key
+const redactSecretGroup = "secret"
+
+// redaction finds the values to redact. Matches rejected by valid are kept.
+type redaction struct {
+	pattern *regexp.Regexp
+	valid   func(match string) bool
+	// secret is the index of the secret group of the pattern, 0 to redact the whole match
+	secret int
+}
+
+func newRedaction(pattern *regexp.Regexp, valid func(match string) bool) redaction {
+	r := redaction{pattern: pattern, valid: valid}
+	for i, name := range pattern.SubexpNames() {
+		if name == redactSecretGroup {
+			r.secret = i
+		}
+	}
+	return r
+}
+
+// redactionDetectors are the built-in detectors of secrets and personal data
+var redactionDetectors = map[string]redaction{
+	"bearer":            newRedaction(regexp.MustCompile(`(?i)\bbearer\s+(?P<secret>[a-z0-9\-._~+/]{8,}=*)`), nil),
+	"jwt":               newRedaction(regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), nil),
+	"connection-string": newRedaction(regexp.MustCompile(`(?i)\b(?:AccountKey|SharedAccessKey|SharedAccessSignature|Password|Pwd|InstrumentationKey)=(?P<secret>[^;\s"']+)`), nil),
+	"email":             newRedaction(regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`), nil),
+	"card":              newRedaction(regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), luhn),
+}
+
+// redactionDetectorNames are the names of the built-in detectors
+var redactionDetectorNames = []string{"bearer", "jwt", "connection-string", "email", "card"}
+
+// processHashKey keys the hashes of redacted values when redact-hash-key is not set. Hashes then only
+// correlate within one run of the plugin, but cannot be reversed by hashing guessed values.
+var processHashKey = newProcessHashKey()
+
+func newProcessHashKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		logrus.WithError(err).Error("Could not generate the key of redacted value hashes")
+	}
+	return key
+}
+
+// parseRedactPatterns parses semicolon separated regexes
+func parseRedactPatterns(val string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for i, expr := range strings.Split(val, ";") {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %d: %v", i+1, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// redactor redacts values with the configured detectors followed by the patterns of the user.
+// It is built once per logger.
+type redactor struct {
+	redactions []redaction
+	mode       string
+	key        []byte
+}
+
+func newRedactor(config Config) *redactor {
+	r := &redactor{mode: config.RedactMode, key: processHashKey}
+	if config.RedactHashKey != "" {
+		r.key = []byte(config.RedactHashKey)
+	}
+	for _, name := range config.Redact {
+		r.redactions = append(r.redactions, redactionDetectors[name])
+	}
+	for _, pattern := range config.RedactPatterns {
+		r.redactions = append(r.redactions, newRedaction(pattern, nil))
+	}
+	return r
+}
+
+// redact removes secrets and personal data from the texts, names and property values of an envelope
+func (l *insightsLogger) redact(envelope *ai.Envelope) {
+	r := l.redactor
+	if r == nil || len(r.redactions) == 0 {
+		return
+	}
+
+	if message, ok := messageData(envelope); ok {
+		message.Message = r.text(message.Message)
+	}
+	if data, ok := envelope.Data.(*ai.Data); ok {
+		switch base := data.BaseData.(type) {
+		case *ai.ExceptionData:
+			for _, exception := range base.Exceptions {
+				exception.TypeName = r.text(exception.TypeName)
+				exception.Message = r.text(exception.Message)
+				exception.Stack = r.text(exception.Stack)
+				for _, frame := range exception.ParsedStack {
+					frame.Method = r.text(frame.Method)
+					frame.Assembly = r.text(frame.Assembly)
+					frame.FileName = r.text(frame.FileName)
+				}
+			}
+		case *ai.RequestData:
+			base.Name = r.text(base.Name)
+			base.Url = r.text(base.Url)
+		case *ai.EventData:
+			base.Name = r.text(base.Name)
+		}
+	}
+	// The operation name repeats the name of a request
+	if name, ok := envelope.Tags[ai.OperationName]; ok {
+		envelope.Tags[ai.OperationName] = r.text(name)
+	}
+
+	properties := envelopeProperties(envelope)
+	for key, val := range properties {
+		redacted, found := r.value(val)
+		if !found {
+			continue
+		}
+		if r.mode == RedactDrop {
+			delete(properties, key)
+		} else {
+			properties[key] = redacted
+		}
+	}
+}
+
+// RedactLocalLine redacts a line before it is written to the local json file, when configured.
+// The local copy is left untouched by default so it can be used to investigate incidents.
+func (l *insightsLogger) RedactLocalLine(line []byte) []byte {
+	if !l.config.RedactLocal || l.redactor == nil {
+		return line
+	}
+	redacted, found := l.redactor.value(string(line))
+	if !found {
+		return line
+	}
+	if l.redactor.mode == RedactDrop {
+		return []byte(constants.RedactedValue)
+	}
+	return []byte(redacted)
+}
+
+// text redacts a text field, which is replaced as a whole when values are dropped
+func (r *redactor) text(text string) string {
+	redacted, found := r.value(text)
+	if found && r.mode == RedactDrop {
+		return constants.RedactedValue
+	}
+	return redacted
+}
+
+// value replaces the matches of the redactions in val and returns whether any was found
+func (r *redactor) value(val string) (string, bool) {
+	found := false
+	for _, redaction := range r.redactions {
+		var out bytes.Buffer
+		last, replaced := 0, false
+		for _, match := range redaction.pattern.FindAllStringSubmatchIndex(val, -1) {
+			start, end := match[0], match[1]
+			if secret := redaction.secret; secret > 0 && match[2*secret] >= 0 {
+				start, end = match[2*secret], match[2*secret+1]
+			}
+			if start == end || (redaction.valid != nil && !redaction.valid(val[match[0]:match[1]])) {
+				continue
+			}
+			out.WriteString(val[last:start])
+			out.WriteString(r.replacement(val[start:end]))
+			last, replaced = end, true
+		}
+		if replaced {
+			out.WriteString(val[last:])
+			val = out.String()
+			found = true
+		}
+	}
+	return val, found
+}
+
+// replacement masks a redacted value, or replaces it by a keyed hash so occurrences of the same value can
+// still be correlated. Without the key, the hash cannot be reversed by hashing guessed values.
+func (r *redactor) replacement(val string) string {
+	if r.mode == RedactHash {
+		mac := hmac.New(sha256.New, r.key)
+		mac.Write([]byte(val))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	return constants.RedactedValue
+}
+
+// luhn validates the check digit of card numbers, ignoring spaces and dashes
+func luhn(number string) bool {
+	sum, double := 0, false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if double {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,227 @@
+package insights
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+)
+
+// Formats of the access logs
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+)
+
+// accessLogPatterns match the Common and Combined Log Formats. Fields following them, such as the
+// duration appended by Traefik or the $request_time of nginx, are captured by the group rest.
+var accessLogPatterns = map[string]*regexp.Regexp{
+	AccessLogCombined: regexp.MustCompile(`^(?P<remote_addr>\S+) \S+ (?P<remote_user>\S+) \[(?P<timestamp>[^\]]+)\] "(?P<method>[A-Z]+) (?P<url>\S+)(?: (?P<protocol>[^"]*))?" (?P<status>\d{3}) (?P<bytes>\d+|-) "(?P<referer>[^"]*)" "(?P<user_agent>[^"]*)"(?P<rest>.*)$`),
+	AccessLogCommon:   regexp.MustCompile(`^(?P<remote_addr>\S+) \S+ (?P<remote_user>\S+) \[(?P<timestamp>[^\]]+)\] "(?P<method>[A-Z]+) (?P<url>\S+)(?: (?P<protocol>[^"]*))?" (?P<status>\d{3}) (?P<bytes>\d+|-)(?P<rest>.*)$`),
+}
+
+// accessLogDuration matches a trailing duration in milliseconds, e.g. 12ms, or in seconds, e.g. 0.012
+var accessLogDuration = regexp.MustCompile(`(?:^|\s)(\d+(?:\.\d+)?)(ms)?$`)
+
+// accessLogOrder tries the combined format first, as common lines are a prefix of combined lines
+var accessLogOrder = []string{AccessLogCombined, AccessLogCommon, AccessLogJSON}
+
+// Fields of JSON access logs written by Traefik, Caddy and nginx log_format escape=json
+var (
+	requestMethodFields = []string{"RequestMethod", "request.method", "request_method", "method"}
+	requestURLFields    = []string{"RequestPath", "request.uri", "request_uri", "uri", "path", "url"}
+	requestHostFields   = []string{"RequestHost", "request.host", "host"}
+	requestSchemeFields = []string{"RequestScheme", "scheme"}
+	requestStatusFields = []string{"DownstreamStatus", "status", "status_code", "response_code"}
+	requestTimeFields   = []string{"StartUTC"}
+)
+
+// requestDurationUnits are the duration fields of JSON access logs and their unit
+var requestDurationUnits = []struct {
+	field string
+	unit  time.Duration
+}{
+	{"Duration", time.Nanosecond},
+	{"duration_ms", time.Millisecond},
+	{"request_time", time.Second},
+	{"duration", time.Second},
+}
+
+// accessRequest is a request parsed from an access log line
+type accessRequest struct {
+	method     string
+	url        string
+	status     int
+	duration   time.Duration
+	timestamp  time.Time
+	properties map[string]string
+}
+
+// parseAccessLog parses a line in one of the configured access log formats
+func (l *insightsLogger) parseAccessLog(line []byte) (accessRequest, bool) {
+	for _, format := range accessLogOrder {
+		if !containsString(l.config.AccessLogs, format) {
+			continue
+		}
+		if format == AccessLogJSON {
+			if request, ok := l.parseJSONAccessLog(line); ok {
+				return request, true
+			}
+		} else if request, ok := parseCommonAccessLog(accessLogPatterns[format], line); ok {
+			return request, true
+		}
+	}
+	return accessRequest{}, false
+}
+
+func parseCommonAccessLog(pattern *regexp.Regexp, line []byte) (accessRequest, bool) {
+	match := pattern.FindStringSubmatch(strings.TrimSpace(string(line)))
+	if match == nil {
+		return accessRequest{}, false
+	}
+
+	request := accessRequest{properties: make(map[string]string)}
+	for i, name := range pattern.SubexpNames() {
+		val := match[i]
+		switch name {
+		case "":
+		case "method":
+			request.method = val
+		case "url":
+			request.url = val
+		case "status":
+			request.status, _ = strconv.Atoi(val)
+		case "timestamp":
+			request.timestamp, _ = parseTimestamp(val)
+		case "rest":
+			if duration := accessLogDuration.FindStringSubmatch(strings.TrimSpace(val)); duration != nil {
+				unit := time.Second
+				if duration[2] == "ms" {
+					unit = time.Millisecond
+				}
+				value, _ := strconv.ParseFloat(duration[1], 64)
+				request.duration = time.Duration(value * float64(unit))
+			}
+		default:
+			if val != "-" && val != "" {
+				request.properties[name] = val
+			}
+		}
+	}
+	return request, true
+}
+
+// parseJSONAccessLog parses JSON access logs, which must hold at least a method, URL and status
+func (l *insightsLogger) parseJSONAccessLog(line []byte) (accessRequest, bool) {
+	fields, err := decodeJSONFields(line)
+	if err != nil {
+		return accessRequest{}, false
+	}
+
+	method, ok := firstField(fields, requestMethodFields)
+	if !ok {
+		return accessRequest{}, false
+	}
+	url, ok := firstField(fields, requestURLFields)
+	if !ok {
+		return accessRequest{}, false
+	}
+	statusField, ok := firstField(fields, requestStatusFields)
+	if !ok {
+		return accessRequest{}, false
+	}
+	status, err := strconv.Atoi(formatField(fields[statusField]))
+	if err != nil {
+		return accessRequest{}, false
+	}
+
+	request := accessRequest{
+		method: formatField(fields[method]),
+		url:    formatField(fields[url]),
+		status: status,
+	}
+	if host, ok := firstField(fields, requestHostFields); ok && strings.HasPrefix(request.url, "/") {
+		scheme := "http"
+		if key, ok := firstField(fields, requestSchemeFields); ok {
+			scheme = formatField(fields[key])
+		}
+		request.url = scheme + "://" + formatField(fields[host]) + request.url
+	}
+	for _, duration := range requestDurationUnits {
+		if value, err := strconv.ParseFloat(formatField(fields[duration.field]), 64); err == nil {
+			request.duration = time.Duration(value * float64(duration.unit))
+			break
+		}
+	}
+	if key, ok := firstField(fields, append(requestTimeFields, l.config.TimestampFields...)); ok {
+		request.timestamp, _ = parseTimestamp(formatField(fields[key]))
+	}
+
+	request.properties = make(map[string]string, len(fields))
+	for key, val := range fields {
+		request.properties[key] = formatField(val)
+	}
+	return request, true
+}
+
+// createInsightsRequest creates request telemetry from an access log line
+func (l *insightsLogger) createInsightsRequest(msg *logger.Message, request accessRequest) *ai.Envelope {
+	ctx := l.messageProperties(msg)
+	for key, val := range request.properties {
+		if _, ok := ctx[key]; !ok {
+			ctx[key] = val
+		}
+	}
+
+	// Requests are named after the method and path, so URLs differing only by their query are grouped
+	path := request.url
+	if i := strings.Index(path, "://"); i >= 0 {
+		if j := strings.Index(path[i+3:], "/"); j >= 0 {
+			path = path[i+3+j:]
+		} else {
+			path = "/"
+		}
+	}
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+
+	data := &ai.RequestData{
+		Ver:          2,
+		Id:           newRequestID(),
+		Name:         request.method + " " + path,
+		Url:          request.url,
+		Duration:     formatDuration(request.duration),
+		ResponseCode: strconv.Itoa(request.status),
+		Success:      request.status < 400,
+		Properties:   ctx,
+	}
+
+	tags := l.createTags()
+	tags[ai.OperationName] = data.Name
+	for tag, val := range l.operationTags(request.properties) {
+		tags[tag] = val
+	}
+	return l.createEnvelope("RequestData", data, tags, l.envelopeTime(msg, parsedLine{Timestamp: request.timestamp}))
+}
+
+// newRequestID returns a random id in the format of W3C span ids
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// formatDuration formats a duration as d.hh:mm:ss.fffffff as expected by Application Insights
+func formatDuration(d time.Duration) string {
+	ticks := int64(d/time.Nanosecond) / 100
+	return fmt.Sprintf("%d.%02d:%02d:%02d.%07d",
+		ticks/864000000000, ticks/36000000000%24, ticks/600000000%60, ticks/10000000%60, ticks%10000000)
+}
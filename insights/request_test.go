@@ -0,0 +1,77 @@
+package insights
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+func createRequest(t *testing.T, formats, line string) (*contracts.Envelope, *contracts.RequestData) {
+	insightsLog := newParsingLogger(t, map[string]string{
+		constants.AccessLogKey:     formats,
+		constants.LineTimestampKey: "true",
+	})
+	msg := logger.NewMessage()
+	msg.Line = []byte(line)
+	envelope := insightsLog.createInsightsMessage(msg)
+
+	require.Equal(t, "Microsoft.ApplicationInsights.RequestData", envelope.Name)
+	data := envelope.Data.(*contracts.Data)
+	require.Equal(t, "RequestData", data.BaseType)
+	return envelope, data.BaseData.(*contracts.RequestData)
+}
+
+func TestCombinedAccessLog(t *testing.T) {
+	envelope, request := createRequest(t, "common,combined",
+		`10.0.0.1 - frank [10/Oct/2018:13:55:36 -0700] "GET /api/users?page=2 HTTP/1.1" 200 2326 "https://example.com/" "curl/7.58.0" 42 "web@docker" "http://172.17.0.3:80" 12ms`)
+	require.Equal(t, "GET /api/users", request.Name)
+	require.Equal(t, "/api/users?page=2", request.Url)
+	require.Equal(t, "200", request.ResponseCode)
+	require.True(t, request.Success)
+	require.Equal(t, "0.00:00:00.0120000", request.Duration)
+	require.Len(t, request.Id, 16)
+	require.Equal(t, "curl/7.58.0", request.Properties["user_agent"])
+	require.Equal(t, "frank", request.Properties["remote_user"])
+	require.Equal(t, "2018-10-10T20:55:36Z", envelope.Time)
+	require.Equal(t, "GET /api/users", envelope.Tags[contracts.OperationName])
+}
+
+func TestCommonAccessLog(t *testing.T) {
+	_, request := createRequest(t, AccessLogCommon,
+		`127.0.0.1 - - [10/Oct/2018:13:55:36 +0000] "POST /login HTTP/1.0" 503 - 0.250`)
+	require.Equal(t, "POST /login", request.Name)
+	require.False(t, request.Success)
+	require.Equal(t, "0.00:00:00.2500000", request.Duration)
+	require.NotContains(t, request.Properties, "remote_user")
+}
+
+func TestJSONAccessLog(t *testing.T) {
+	_, request := createRequest(t, AccessLogJSON,
+		`{"RequestMethod":"DELETE","RequestHost":"api.local","RequestPath":"/items/7","RequestScheme":"https","DownstreamStatus":404,"Duration":1500000000,"traceparent":"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}`)
+	require.Equal(t, "DELETE /items/7", request.Name)
+	require.Equal(t, "https://api.local/items/7", request.Url)
+	require.Equal(t, "404", request.ResponseCode)
+	require.False(t, request.Success)
+	require.Equal(t, "0.00:00:01.5000000", request.Duration)
+
+	_, request = createRequest(t, AccessLogJSON,
+		`{"request":{"method":"GET","uri":"/"},"status":200,"duration":0.004}`)
+	require.Equal(t, "GET /", request.Name)
+	require.Equal(t, "0.00:00:00.0040000", request.Duration)
+}
+
+func TestAccessLogDisabled(t *testing.T) {
+	insightsLog := newParsingLogger(t, map[string]string{constants.AccessLogKey: AccessLogJSON})
+	msg := logger.NewMessage()
+	msg.Line = []byte(`127.0.0.1 - - [10/Oct/2018:13:55:36 +0000] "GET / HTTP/1.0" 200 -`)
+	envelope := insightsLog.createInsightsMessage(msg)
+	require.Equal(t, "Microsoft.ApplicationInsights.MessageData", envelope.Name)
+}
+
+func TestFormatDuration(t *testing.T) {
+	require.Equal(t, "1.02:03:04.5000000", formatDuration(26*time.Hour+3*time.Minute+4500*time.Millisecond))
+}
@@ -0,0 +1,113 @@
+package insights
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+)
+
+// severityLevels maps the accepted names of each severity, including common abbreviations
+var severityLevels = map[string]ai.SeverityLevel{
+	"verbose":     ai.Verbose,
+	"debug":       ai.Verbose,
+	"trace":       ai.Verbose,
+	"information": ai.Information,
+	"info":        ai.Information,
+	"warning":     ai.Warning,
+	"warn":        ai.Warning,
+	"error":       ai.Error,
+	"err":         ai.Error,
+	"critical":    ai.Critical,
+	"fatal":       ai.Critical,
+	// Single letter levels written by klog
+	"i": ai.Information,
+	"w": ai.Warning,
+	"e": ai.Error,
+	"f": ai.Critical,
+}
+
+// SeverityRule sets the severity of the lines matching Pattern
+type SeverityRule struct {
+	Pattern *regexp.Regexp
+	Level   ai.SeverityLevel
+}
+
+func parseSeverity(val string) (ai.SeverityLevel, error) {
+	level, ok := severityLevels[strings.ToLower(strings.TrimSpace(val))]
+	if !ok {
+		return ai.Verbose, fmt.Errorf("unknown severity %q, expected one of verbose, information, warning, error or critical", val)
+	}
+	return level, nil
+}
+
+// parseSeverityRules parses rules such as error=^ERROR;critical=panic:
+func parseSeverityRules(val string) ([]SeverityRule, error) {
+	var rules []SeverityRule
+	for i, rule := range strings.Split(val, ";") {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+
+		pair := strings.SplitN(rule, "=", 2)
+		if len(pair) != 2 || pair[1] == "" {
+			return nil, fmt.Errorf("rule %d must be of the form severity=regex", i+1)
+		}
+
+		level, err := parseSeverity(pair[0])
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+
+		pattern, err := regexp.Compile(pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		rules = append(rules, SeverityRule{Pattern: pattern, Level: level})
+	}
+	return rules, nil
+}
+
+// resolveSeverity decides the severity of a line. The level parsed from a structured line wins,
+// then the first matching rule and finally the stream the line was written to.
+func (l *insightsLogger) resolveSeverity(msg *logger.Message, level string) ai.SeverityLevel {
+	if level != "" {
+		if parsed, err := parseSeverity(level); err == nil {
+			return parsed
+		}
+	}
+
+	for _, rule := range l.config.SeverityRules {
+		if rule.Pattern.Match(msg.Line) {
+			return rule.Level
+		}
+	}
+
+	if msg.Source == "stderr" {
+		return l.config.StderrSeverity
+	}
+	return l.config.StdoutSeverity
+}
+
+// structuredLevel returns the value of the first level field of a JSON line
+func structuredLevel(line []byte, names []string) (string, bool) {
+	line = bytes.TrimSpace(line)
+	if len(names) == 0 || len(line) == 0 || line[0] != '{' {
+		return "", false
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return "", false
+	}
+	for _, name := range names {
+		if level, ok := fields[name].(string); ok {
+			return level, true
+		}
+	}
+	return "", false
+}
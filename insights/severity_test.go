@@ -0,0 +1,60 @@
+package insights
+
+import (
+	"testing"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+func TestParseSeverityRules(t *testing.T) {
+	rules, err := parseSeverityRules("error=^ERROR; critical=panic:;")
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, contracts.Error, rules[0].Level)
+	require.Equal(t, contracts.Critical, rules[1].Level)
+	require.True(t, rules[1].Pattern.MatchString("panic: runtime error"))
+
+	_, err = parseSeverityRules("error")
+	require.Error(t, err)
+
+	_, err = parseSeverityRules("loud=^ERROR")
+	require.Error(t, err)
+
+	_, err = parseSeverityRules("error=[")
+	require.Error(t, err)
+}
+
+func TestResolveSeverity(t *testing.T) {
+	config, err := InitializeEnv(logger.Info{
+		Config: map[string]string{
+			constants.TokenKey:         "some token",
+			constants.SeverityRulesKey: "error=^ERROR;critical=panic:",
+		},
+	}, DefaultConfig())
+	require.NoError(t, err)
+	insightsLog := insightsLogger{config: config}
+
+	cases := []struct {
+		source   string
+		line     string
+		severity contracts.SeverityLevel
+	}{
+		{"stdout", "GET / 200", contracts.Information},
+		{"stderr", "connection reset", contracts.Warning},
+		{"stdout", "ERROR failed to connect", contracts.Error},
+		{"stderr", "panic: runtime error", contracts.Critical},
+		{"stdout", `{"level":"warn","msg":"ERROR in the message"}`, contracts.Warning},
+		{"stderr", `{"level":"notice","msg":"unknown level"}`, contracts.Warning},
+		{"stdout", `{"msg":"no level"}`, contracts.Information},
+	}
+
+	for _, c := range cases {
+		msg := logger.NewMessage()
+		msg.Source = c.source
+		msg.Line = []byte(c.line)
+		require.Equal(t, c.severity, insightsLog.resolveSeverity(msg, insightsLog.parseLine(msg.Line).Level), c.line)
+	}
+}
@@ -1,23 +1,33 @@
 package insights
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/michael.golfi/appinsights/constants"
 )
 
 func (l *insightsLogger) worker() {
-	timer := time.NewTicker(l.postMessagesFrequency)
+	timer := time.NewTicker(l.settings.config.BatchInterval)
 	var messages []*contracts.Envelope
 	for {
 		select {
 		case message, open := <-l.stream:
 			if !open {
-				l.postMessages(messages, true)
+				// A reload requested before closing still applies to the last messages
+				select {
+				case settings := <-l.reconfigure:
+					l.settings.transport.CloseIdleConnections()
+					l.settings = settings
+				default:
+				}
+				l.postMessages(append(messages, l.metricEnvelopes()...), true)
 				l.lock.Lock()
 
-				l.transport.CloseIdleConnections()
+				l.settings.transport.CloseIdleConnections()
 				l.closed = true
 				l.closedCond.Signal()
 
@@ -28,16 +38,66 @@ func (l *insightsLogger) worker() {
 			// Only sending when we get exactly to the batch size,
 			// This also helps not to fire postMessages on every new message,
 			// when previous try failed.
-			if len(messages)%l.postMessagesBatchSize == 0 {
+			if len(messages)%l.settings.config.BatchSize == 0 {
 				messages = l.postMessages(messages, false)
 			}
 		case <-timer.C:
-			messages = l.postMessages(messages, false)
+			// Metrics aggregated over the interval are sent along with the batch
+			l.adjustSampling()
+			messages = l.postMessages(append(messages, l.metricEnvelopes()...), false)
+		case settings := <-l.reconfigure:
+			// Messages buffered so far are kept and sent with the new settings
+			l.settings.transport.CloseIdleConnections()
+			l.settings = settings
+
+			timer.Stop()
+			timer = time.NewTicker(l.settings.config.BatchInterval)
+			if len(messages) >= l.settings.config.BatchSize {
+				messages = l.postMessages(messages, false)
+			}
+		}
+	}
+}
+
+// Reconfigure applies new plugin defaults to a running logger. Log opts of the container still
+// take precedence, so only settings the container did not override change. The endpoint, HTTP client,
+// instrumentation key and batching are swapped by the worker without dropping queued messages.
+// The connection of the resolved settings is verified through check, which the loggers of a reload share.
+// A reload the worker has not applied yet is replaced, so Reconfigure never blocks on a busy worker.
+func (l *insightsLogger) Reconfigure(defaults Config, check *ConnectionCheck) error {
+	config, err := InitializeEnv(l.logCtx, defaults)
+	if err != nil {
+		return err
+	}
+	if err := check.Verify(config); err != nil {
+		return err
+	}
+
+	settings, err := newTransportSettings(config)
+	if err != nil {
+		return err
+	}
+
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.closedCond != nil {
+		return fmt.Errorf("%s: driver is closed", constants.DriverName)
+	}
+	for {
+		select {
+		case l.reconfigure <- settings:
+			logrus.WithField("id", l.logCtx.ContainerID).WithField("sources", config.Sources).Debug("Reconfigured logger")
+			return nil
+		case dropped := <-l.reconfigure:
+			// The new settings replace the pending reload
+			dropped.transport.CloseIdleConnections()
 		}
 	}
 }
 
 func (l *insightsLogger) Close() error {
+	l.flushStackTraces()
+
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	if l.closedCond == nil {
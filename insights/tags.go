@@ -0,0 +1,95 @@
+package insights
+
+import (
+	"fmt"
+	"strings"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+// Sources of the cloud role and cloud role instance tags
+const (
+	RoleSourceComposeService = "compose-service"
+	RoleSourceSwarmService   = "swarm-service"
+	RoleSourceContainerName  = "container-name"
+	RoleSourceImageName      = "image-name"
+	RoleSourceContainerID    = "container-id"
+	RoleSourceHostname       = "hostname"
+)
+
+// Labels set by docker compose and swarm on the containers of a service
+const (
+	composeServiceLabel = "com.docker.compose.service"
+	swarmServiceLabel   = "com.docker.swarm.service.name"
+)
+
+// sdkVersion identifies the plugin as the source of the telemetry
+var sdkVersion = fmt.Sprintf("docker-%s:%s", constants.DriverName, constants.Version)
+
+// contextTags resolves the context tags shared by every envelope of a container once, when the logger is created
+func contextTags(logCtx logger.Info, config Config) map[string]string {
+	tags := make(map[string]string, 3)
+	tags[ai.InternalSdkVersion] = sdkVersion
+
+	role := config.Role
+	for _, source := range config.RoleSources {
+		if role != "" {
+			break
+		}
+		role = tagSource(logCtx, source)
+	}
+	if role != "" {
+		tags[ai.CloudRole] = role
+	}
+
+	if instance := tagSource(logCtx, config.RoleInstanceSource); instance != "" {
+		tags[ai.CloudRoleInstance] = instance
+	}
+	return tags
+}
+
+// createTags returns a copy of the context tags of the container, which the envelope may extend
+func (l *insightsLogger) createTags() map[string]string {
+	tags := make(map[string]string, len(l.tags)+3)
+	for key, val := range l.tags {
+		tags[key] = val
+	}
+	return tags
+}
+
+// tagSource returns the value of a tag source for the container being logged
+func tagSource(logCtx logger.Info, source string) string {
+	switch source {
+	case RoleSourceComposeService:
+		return logCtx.ContainerLabels[composeServiceLabel]
+	case RoleSourceSwarmService:
+		return logCtx.ContainerLabels[swarmServiceLabel]
+	case RoleSourceContainerName:
+		return strings.TrimPrefix(logCtx.ContainerName, "/")
+	case RoleSourceImageName:
+		return imageName(logCtx.ContainerImageName)
+	case RoleSourceContainerID:
+		return logCtx.ContainerID
+	case RoleSourceHostname:
+		hostname, err := logCtx.Hostname()
+		if err != nil {
+			logrus.WithField("id", logCtx.ContainerID).WithError(err).Warn("Could not resolve the cloud role instance")
+		}
+		return hostname
+	}
+	return ""
+}
+
+// imageName strips the tag and digest from an image reference such as registry:5000/app:1.0
+func imageName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
@@ -0,0 +1,139 @@
+package insights
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+func newTaggingLogger(t *testing.T, opts, labels map[string]string) insightsLogger {
+	opts[constants.TokenKey] = "some token"
+	info := logger.Info{
+		Config:             opts,
+		ContainerID:        "5e3a1b2c",
+		ContainerName:      "/project_web_1",
+		ContainerImageName: "registry:5000/team/web:1.2@sha256:abc",
+		ContainerLabels:    labels,
+	}
+	config, err := InitializeEnv(info, DefaultConfig())
+	require.NoError(t, err)
+	return insightsLogger{config: config, logCtx: info, tags: contextTags(info, config)}
+}
+
+func TestCreateTags(t *testing.T) {
+	insightsLog := newTaggingLogger(t, map[string]string{}, map[string]string{
+		"com.docker.compose.service": "web",
+	})
+	tags := insightsLog.createTags()
+	require.Equal(t, "web", tags[contracts.CloudRole])
+	require.Equal(t, "5e3a1b2c", tags[contracts.CloudRoleInstance])
+	require.Equal(t, "docker-appinsights:"+constants.Version, tags[contracts.InternalSdkVersion])
+
+	insightsLog = newTaggingLogger(t, map[string]string{}, map[string]string{})
+	require.Equal(t, "project_web_1", insightsLog.createTags()[contracts.CloudRole])
+
+	insightsLog = newTaggingLogger(t, map[string]string{constants.RoleKey: "frontend"}, map[string]string{
+		"com.docker.compose.service": "web",
+	})
+	require.Equal(t, "frontend", insightsLog.createTags()[contracts.CloudRole])
+}
+
+func TestCreateTagsSources(t *testing.T) {
+	insightsLog := newTaggingLogger(t, map[string]string{
+		constants.RoleSourceKey:         "swarm-service, image-name",
+		constants.RoleInstanceSourceKey: "hostname",
+	}, map[string]string{})
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	tags := insightsLog.createTags()
+	require.Equal(t, "registry:5000/team/web", tags[contracts.CloudRole])
+	require.Equal(t, hostname, tags[contracts.CloudRoleInstance])
+
+	insightsLog = newTaggingLogger(t, map[string]string{}, map[string]string{
+		constants.LabelPrefix + constants.RoleSourceKey:         "swarm-service",
+		constants.LabelPrefix + constants.RoleInstanceSourceKey: "container-name",
+		"com.docker.swarm.service.name":                         "stack_api",
+	})
+	tags = insightsLog.createTags()
+	require.Equal(t, "stack_api", tags[contracts.CloudRole])
+	require.Equal(t, "project_web_1", tags[contracts.CloudRoleInstance])
+
+	_, err = InitializeEnv(logger.Info{Config: map[string]string{
+		constants.TokenKey:      "some token",
+		constants.RoleSourceKey: "container-name,label",
+	}}, DefaultConfig())
+	require.Error(t, err)
+}
+
+func TestOperationTags(t *testing.T) {
+	insightsLog := newTaggingLogger(t, map[string]string{constants.FormatKey: FormatJSON}, map[string]string{})
+
+	cases := []struct {
+		line     string
+		id       string
+		parentID string
+	}{
+		{`{"msg":"a","traceparent":"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}`, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{`{"msg":"a","traceparent":"00-00000000000000000000000000000000-00f067aa0ba902b7-01","trace_id":"abc"}`, "abc", ""},
+		{`{"msg":"a","operation_Id":"abc","operation_ParentId":"def"}`, "abc", "def"},
+		{`{"msg":"a","span_id":"def"}`, "", ""},
+		{`{"msg":"a"}`, "", ""},
+	}
+
+	for _, c := range cases {
+		msg := logger.NewMessage()
+		msg.Line = []byte(c.line)
+		envelope := insightsLog.createInsightsMessage(msg)
+		require.Equal(t, c.id, envelope.Tags[contracts.OperationId], c.line)
+		require.Equal(t, c.parentID, envelope.Tags[contracts.OperationParentId], c.line)
+	}
+
+	insightsLog = newTaggingLogger(t, map[string]string{
+		constants.FormatKey:               FormatLogfmt,
+		constants.OperationIDFieldKey:     "request",
+		constants.OperationParentFieldKey: "caller",
+	}, map[string]string{})
+	msg := logger.NewMessage()
+	msg.Line = []byte(`msg=served request=r-42 caller=c-7 trace_id=ignored`)
+	envelope := insightsLog.createInsightsMessage(msg)
+	require.Equal(t, "r-42", envelope.Tags[contracts.OperationId])
+	require.Equal(t, "c-7", envelope.Tags[contracts.OperationParentId])
+}
+
+func TestContextFields(t *testing.T) {
+	info := logger.Info{
+		Config:        map[string]string{constants.TokenKey: "some token"},
+		ContainerID:   "5e3a1b2c",
+		ContainerName: "/web",
+		ContainerEnv:  []string{"PASSWORD=hunter2", "REGION=eu", "TIER=web"},
+	}
+
+	config, err := InitializeEnv(info, DefaultConfig())
+	require.NoError(t, err)
+	ctx, err := mapLogCtx(info, config)
+	require.NoError(t, err)
+	require.Equal(t, "5e3a1b2c", ctx["ContainerID"])
+	require.NotContains(t, ctx, "ContainerEnv")
+	require.NotContains(t, ctx, "Config")
+
+	info.Config[constants.EnvAllowlistKey] = "REGION,TIER"
+	info.Config[constants.ExcludeFieldsKey] = "ContainerID,LogPath"
+	config, err = InitializeEnv(info, DefaultConfig())
+	require.NoError(t, err)
+	ctx, err = mapLogCtx(info, config)
+	require.NoError(t, err)
+	require.Equal(t, `["REGION=eu","TIER=web"]`, ctx["ContainerEnv"])
+	require.NotContains(t, ctx, "ContainerID")
+	require.NotContains(t, ctx, "LogPath")
+	require.Equal(t, "/web", ctx["ContainerName"])
+
+	info.Config[constants.IncludeFieldsKey] = "ContainerName,Env"
+	_, err = InitializeEnv(info, DefaultConfig())
+	require.Error(t, err)
+}
@@ -0,0 +1,90 @@
+package insights
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+// instrumentationKey provides the key stamped on every envelope when it is sent.
+// A key read from a file is re-read whenever the file changes, so keys can be rotated
+// without restarting containers.
+type instrumentationKey struct {
+	lock    sync.Mutex
+	value   string
+	path    string
+	refresh time.Duration
+	checked time.Time
+	modTime time.Time
+	size    int64
+}
+
+func newInstrumentationKey(config Config) (*instrumentationKey, error) {
+	key := &instrumentationKey{
+		value:   config.Token,
+		path:    config.TokenFile,
+		refresh: constants.TokenFileRefresh,
+	}
+	if key.path == "" {
+		return key, nil
+	}
+
+	if err := key.reload(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Get returns the current instrumentation key, checking the token file for changes at most once per refresh interval
+func (k *instrumentationKey) Get() string {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if k.path != "" && time.Since(k.checked) >= k.refresh {
+		// Keep sending with the previous key until the file is readable again
+		if err := k.reload(); err != nil {
+			logrus.WithError(err).WithField("file", k.path).Warn("Could not reload instrumentation key")
+		}
+	}
+	return k.value
+}
+
+func (k *instrumentationKey) reload() error {
+	k.checked = time.Now()
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", k.path, err)
+	}
+	if info.ModTime().Equal(k.modTime) && info.Size() == k.size {
+		return nil
+	}
+
+	value, err := readTokenFile(k.path)
+	if err != nil {
+		return err
+	}
+
+	k.value = value
+	k.modTime = info.ModTime()
+	k.size = info.Size()
+	return nil
+}
+
+func readTokenFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return value, nil
+}
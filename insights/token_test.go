@@ -0,0 +1,108 @@
+package insights
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/michael.golfi/appinsights/constants"
+)
+
+func TestInstrumentationKeyFromToken(t *testing.T) {
+	key, err := newInstrumentationKey(Config{Token: "some token"})
+	require.NoError(t, err)
+	require.Equal(t, "some token", key.Get())
+}
+
+func TestInstrumentationKeyFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "appinsights")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ikey")
+	require.NoError(t, ioutil.WriteFile(path, []byte("first key\n"), 0600))
+
+	key, err := newInstrumentationKey(Config{TokenFile: path})
+	require.NoError(t, err)
+	require.Equal(t, "first key", key.Get())
+
+	// Rotate the key, the file is only checked once per refresh interval
+	require.NoError(t, ioutil.WriteFile(path, []byte("second key"), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	require.Equal(t, "first key", key.Get())
+
+	key.refresh = 0
+	require.Equal(t, "second key", key.Get())
+
+	// A missing file keeps the previous key
+	require.NoError(t, os.Remove(path))
+	require.Equal(t, "second key", key.Get())
+
+	_, err = newInstrumentationKey(Config{TokenFile: path})
+	require.Error(t, err)
+}
+
+func TestTokenFileLogOpt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "appinsights")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ikey")
+	require.NoError(t, ioutil.WriteFile(path, []byte("file key"), 0600))
+
+	config, err := InitializeEnv(logger.Info{
+		Config: map[string]string{
+			constants.TokenFileKey: path,
+		},
+	}, DefaultConfig())
+	require.NoError(t, err)
+	require.Equal(t, path, config.TokenFile)
+	require.Equal(t, "", config.Token)
+
+	_, err = InitializeEnv(logger.Info{
+		Config: map[string]string{
+			constants.TokenFileKey: path,
+			constants.TokenKey:     "some token",
+		},
+	}, DefaultConfig())
+	require.Error(t, err)
+
+	_, err = InitializeEnv(logger.Info{
+		Config: map[string]string{
+			constants.TokenFileKey: filepath.Join(dir, "missing"),
+		},
+	}, DefaultConfig())
+	require.Error(t, err)
+
+	// A container token replaces the token file of the defaults
+	config, err = InitializeEnv(logger.Info{
+		Config: map[string]string{
+			constants.TokenKey: "some token",
+		},
+	}, config)
+	require.NoError(t, err)
+	require.Equal(t, "some token", config.Token)
+	require.Equal(t, "", config.TokenFile)
+}
+
+func TestScrubOptions(t *testing.T) {
+	cfg := map[string]string{
+		constants.TokenKey:            "some token",
+		constants.ConnectionStringKey: "InstrumentationKey=some token",
+		constants.BatchSizeKey:        "10",
+	}
+
+	scrubbed := scrubOptions(cfg)
+	require.Equal(t, constants.RedactedValue, scrubbed[constants.TokenKey])
+	require.Equal(t, constants.RedactedValue, scrubbed[constants.ConnectionStringKey])
+	require.Equal(t, "10", scrubbed[constants.BatchSizeKey])
+	require.Equal(t, "some token", cfg[constants.TokenKey])
+
+	ctx, err := mapLogCtx(logger.Info{Config: cfg}, Config{IncludeFields: []string{"Config"}})
+	require.NoError(t, err)
+	require.NotContains(t, ctx["Config"], "some token")
+}
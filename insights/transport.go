@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
 
 	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
 	"github.com/docker/docker/pkg/urlutil"
@@ -35,6 +36,41 @@ func parseURL(endpoint string) (*url.URL, error) {
 	return insightsURL, nil
 }
 
+// VerifyConnection checks the endpoint of config can be reached, when verify-connection is enabled
+func VerifyConnection(config Config) error {
+	if !config.VerifyConnection {
+		return nil
+	}
+	return verifyInsightsConnection(config.Endpoint)
+}
+
+// ConnectionCheck verifies each endpoint once, so a reload checks every endpoint in use a single time
+type ConnectionCheck struct {
+	lock    sync.Mutex
+	results map[string]error
+}
+
+// NewConnectionCheck creates a check remembering the result of every endpoint it verified
+func NewConnectionCheck() *ConnectionCheck {
+	return &ConnectionCheck{results: make(map[string]error)}
+}
+
+// Verify checks the endpoint of config can be reached, when verify-connection is enabled
+func (c *ConnectionCheck) Verify(config Config) error {
+	if !config.VerifyConnection {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err, ok := c.results[config.Endpoint]; ok {
+		return err
+	}
+	err := verifyInsightsConnection(config.Endpoint)
+	c.results[config.Endpoint] = err
+	return err
+}
+
 func verifyInsightsConnection(uri string) error {
 	client := http.Client{}
 	req, err := http.NewRequest(http.MethodOptions, uri, nil)
@@ -77,8 +113,8 @@ func (l *insightsLogger) postMessages(messages []*contracts.Envelope, lastChance
 	ctx, cancel := context.WithTimeout(context.Background(), l.sendTimeout)
 	defer cancel()
 
-	for i := 0; i < messagesLen; i += l.postMessagesBatchSize {
-		upperBound := i + l.postMessagesBatchSize
+	for i := 0; i < messagesLen; i += l.settings.config.BatchSize {
+		upperBound := i + l.settings.config.BatchSize
 		if upperBound > messagesLen {
 			upperBound = messagesLen
 		}
@@ -93,7 +129,10 @@ func (l *insightsLogger) postMessages(messages []*contracts.Envelope, lastChance
 				// Not all sent, but buffer has got to its maximum, let's log all messages
 				// we could not send and return buffer minus one batch size
 				for j := i; j < upperBound; j++ {
-					if jsonEvent, err := json.Marshal(messages[j]); err != nil {
+					// Never print the instrumentation key to the daemon log
+					scrubbed := *messages[j]
+					scrubbed.IKey = constants.RedactedValue
+					if jsonEvent, err := json.Marshal(&scrubbed); err != nil {
 						logrus.Error(err)
 					} else {
 						logrus.Error(fmt.Errorf("failed to send a message '%s'", string(jsonEvent)))
@@ -120,8 +159,8 @@ func (l *insightsLogger) tryPostMessages(ctx context.Context, messages []*contra
 	var err error
 	// If gzip compression is enabled - create gzip writer with specified compression
 	// level. If gzip compression is disabled, use standard buffer as a writer
-	if l.gzipCompression {
-		gzipWriter, err = gzip.NewWriterLevel(&buffer, l.gzipCompressionLevel)
+	if l.settings.config.GzipCompression {
+		gzipWriter, err = gzip.NewWriterLevel(&buffer, l.settings.config.GzipCompressionLevel)
 		if err != nil {
 			return err
 		}
@@ -129,7 +168,10 @@ func (l *insightsLogger) tryPostMessages(ctx context.Context, messages []*contra
 	} else {
 		writer = &buffer
 	}
+	// The key is stamped when sending so a rotated key also applies to buffered messages
+	ikey := l.settings.key.Get()
 	for _, message := range messages {
+		message.IKey = ikey
 		jsonEvent, err := json.Marshal(message)
 		if err != nil {
 			return err
@@ -139,22 +181,22 @@ func (l *insightsLogger) tryPostMessages(ctx context.Context, messages []*contra
 		}
 	}
 	// If gzip compression is enabled, tell it, that we are done
-	if l.gzipCompression {
+	if l.settings.config.GzipCompression {
 		err = gzipWriter.Close()
 		if err != nil {
 			return err
 		}
 	}
-	req, err := http.NewRequest("POST", l.url, bytes.NewBuffer(buffer.Bytes()))
+	req, err := http.NewRequest("POST", l.settings.config.Endpoint, bytes.NewBuffer(buffer.Bytes()))
 	if err != nil {
 		return err
 	}
 	req = req.WithContext(ctx)
 	// Tell if we are sending gzip compressed body
-	if l.gzipCompression {
+	if l.settings.config.GzipCompression {
 		req.Header.Set("Content-Encoding", "gzip")
 	}
-	res, err := l.client.Do(req)
+	res, err := l.settings.client.Do(req)
 	if err != nil {
 		return err
 	}
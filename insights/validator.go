@@ -2,44 +2,80 @@ package insights
 
 import (
 	"fmt"
-	"gitlab.com/michael.golfi/appinsights/constants"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	ai "github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
 	"github.com/docker/docker/daemon/logger"
-	"strconv"
-	"github.com/sirupsen/logrus"
+	"gitlab.com/michael.golfi/appinsights/constants"
 )
 
-func InitializeEnv(info logger.Info) error {
-	if err := validateLogOpt(info.Config); err != nil {
-		return err
+// connectionString holds the settings derived from an Application Insights connection string
+type connectionString struct {
+	InstrumentationKey string
+	IngestionURL       string
+}
+
+// optionErrors collects every invalid log opt so they can be reported at once
+type optionErrors []error
+
+func (e optionErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%s: invalid log opts: %s", constants.DriverName, strings.Join(msgs, "; "))
+}
+
+func (e *optionErrors) add(name string, err error) {
+	if err != nil {
+		*e = append(*e, fmt.Errorf("%s: %v", name, err))
 	}
+}
 
-	// Instrumentation Token is required parameter
-	token, ok := info.Config[constants.TokenKey]
-	if !ok {
-		return fmt.Errorf("%s: %s is expected", constants.DriverName, constants.TokenKey)
+func (e optionErrors) errOrNil() error {
+	if len(e) == 0 {
+		return nil
 	}
+	return e
+}
 
-	// Merge configurations
-	var (
-		endpoint             = getAdvancedOption(info, constants.EndpointKey, constants.Endpoint)
-		skipVerify           = getAdvancedOptionBool(info, constants.InsecureSkipVerifyKey, constants.InsecureSkipVerify)
-		gzipCompression      = getAdvancedOptionBool(info, constants.GzipCompressionKey, constants.GzipCompression)
-		gzipCompressionLevel = getAdvancedOptionInt(info, constants.GzipCompressionLevelKey, constants.GzipCompressionLevel)
-		verifyConnection     = getAdvancedOptionBool(info, constants.VerifyConnectionKey, constants.VerifyConnection)
-		batchSize            = getAdvancedOptionInt(info, constants.BatchSizeKey, constants.BatchSize)
-		batchInterval        = getAdvancedOptionDuration(info, constants.BatchIntervalKey, constants.BatchInterval)
-	)
+// InitializeEnv validates the log opts in info and merges them over defaults
+// into the configuration of a single logger.
+func InitializeEnv(info logger.Info, defaults Config) (Config, error) {
+	if len(info.Config) == 0 {
+		return Config{}, fmt.Errorf("configuration cannot be empty")
+	}
 
-	constants.Endpoint = endpoint
-	constants.Token = token
-	constants.InsecureSkipVerify = skipVerify
-	constants.GzipCompression = gzipCompression
-	constants.GzipCompressionLevel = gzipCompressionLevel
-	constants.VerifyConnection = verifyConnection
-	constants.BatchSize = batchSize
-	constants.BatchInterval = batchInterval
-	return nil
+	config, errs := parseLogOpt(info, defaults, SourceLogOpt)
+
+	// Container labels are layered over the log opts
+	labelOpts, labelErrs := getLabelOptions(info.ContainerLabels)
+	errs = append(errs, labelErrs...)
+	if len(labelOpts) > 0 {
+		var invalid optionErrors
+		config, invalid = parseLogOpt(logger.Info{Config: labelOpts}, config, SourceLabel)
+		for _, err := range invalid {
+			errs = append(errs, fmt.Errorf("%s%v", constants.LabelPrefix, err))
+		}
+	}
+
+	// Instrumentation Token is required parameter, either directly, through a connection string,
+	// from a file or from the plugin defaults
+	_, hasToken := info.Config[constants.TokenKey]
+	_, hasConnectionString := info.Config[constants.ConnectionStringKey]
+	_, hasTokenFile := info.Config[constants.TokenFileKey]
+	if !hasToken && !hasConnectionString && !hasTokenFile && defaults.Token == "" && defaults.TokenFile == "" {
+		errs = append(errs, fmt.Errorf("%s, %s or %s is expected", constants.TokenKey, constants.ConnectionStringKey, constants.TokenFileKey))
+	}
+
+	if err := errs.errOrNil(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
 }
 
 func validateLogOpt(cfg map[string]string) error {
@@ -47,23 +83,181 @@ func validateLogOpt(cfg map[string]string) error {
 		return fmt.Errorf("configuration cannot be empty")
 	}
 
-	for key := range cfg {
-		switch key {
-		case constants.EndpointKey:
-		case constants.TokenKey:
-		case constants.InsecureSkipVerifyKey:
-		case constants.GzipCompressionKey:
-		case constants.GzipCompressionLevelKey:
-		case constants.VerifyConnectionKey:
-		case constants.BatchSizeKey:
-		case constants.BatchIntervalKey:
-		default:
-			return fmt.Errorf("unknown log opt '%s' for %s log driver", key, constants.DriverName)
+	_, errs := parseLogOpt(logger.Info{Config: cfg}, DefaultConfig(), SourceLogOpt)
+	return errs.errOrNil()
+}
+
+// parseLogOpt parses and range checks every log opt in info, merging them over defaults
+// and recording source as the origin of every value that is set.
+// All problems are collected rather than stopping at the first one.
+func parseLogOpt(info logger.Info, defaults Config, source string) (Config, optionErrors) {
+	var errs optionErrors
+	config := defaults
+	config.Sources = make(map[string]string, len(defaults.Sources)+len(info.Config))
+	for key, origin := range defaults.Sources {
+		config.Sources[key] = origin
+	}
+
+	keys := make([]string, 0, len(info.Config))
+	for key := range info.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		opt, ok := LookupOption(key)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown log opt '%s' for %s log driver", key, constants.DriverName))
+			continue
+		}
+		errs.add(key, opt.apply(info, &config))
+		if info.Config[key] != "" {
+			config.Sources[key] = source
+		}
+	}
+
+	// A connection string replaces the endpoint and token of lower layers. A connection string
+	// inherited from defaults has already been applied to the endpoint and token of defaults.
+	if val := info.Config[constants.ConnectionStringKey]; val != "" {
+		conn, err := parseConnectionString(val)
+		errs.add(constants.ConnectionStringKey, err)
+		if err == nil {
+			errs.add(constants.ConnectionStringKey, validateConnectionString(info.Config, conn))
+			config.Endpoint = conn.IngestionURL
+			config.Token = conn.InstrumentationKey
+			config.Sources[constants.EndpointKey] = source
+			config.Sources[constants.TokenKey] = source
 		}
 	}
+
+	// A token file replaces the token of lower layers and the other way around
+	if val := info.Config[constants.TokenFileKey]; val != "" {
+		if info.Config[constants.TokenKey] != "" || info.Config[constants.ConnectionStringKey] != "" {
+			errs.add(constants.TokenFileKey, fmt.Errorf("cannot be combined with %s or %s", constants.TokenKey, constants.ConnectionStringKey))
+		}
+		_, err := readTokenFile(val)
+		errs.add(constants.TokenFileKey, err)
+		config.Token = ""
+	} else if info.Config[constants.TokenKey] != "" || info.Config[constants.ConnectionStringKey] != "" {
+		config.TokenFile = ""
+	}
+	return config, errs
+}
+
+// getLabelOptions extracts the log opt overrides from the container labels
+func getLabelOptions(labels map[string]string) (map[string]string, optionErrors) {
+	var errs optionErrors
+	opts := make(map[string]string)
+	for label, val := range labels {
+		if !strings.HasPrefix(label, constants.LabelPrefix) {
+			continue
+		}
+
+		// Only options marked as Label in the registry may be overridden.
+		// Options deciding where telemetry is sent, how it is transported and which metadata or secrets it may
+		// carry are deliberately left out.
+		key := strings.TrimPrefix(label, constants.LabelPrefix)
+		if opt, ok := LookupOption(key); !ok || !opt.Label {
+			errs = append(errs, fmt.Errorf("label '%s' is not allowed to override log opts", label))
+			continue
+		}
+		opts[key] = val
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return opts, errs
+}
+
+// isSecretOption reports whether the value of the log opt named key must never be emitted or logged
+func isSecretOption(key string) bool {
+	opt, ok := LookupOption(key)
+	return ok && opt.Secret
+}
+
+// scrubOptions returns a copy of cfg with the values of secret log opts redacted
+func scrubOptions(cfg map[string]string) map[string]string {
+	out := make(map[string]string, len(cfg))
+	for key, val := range cfg {
+		if isSecretOption(key) && val != "" {
+			val = constants.RedactedValue
+		}
+		out[key] = val
+	}
+	return out
+}
+
+// validateConnectionString ensures the connection string does not contradict the endpoint or token log opts
+func validateConnectionString(cfg map[string]string, conn connectionString) error {
+	if endpoint := cfg[constants.EndpointKey]; endpoint != "" && endpoint != conn.IngestionURL {
+		return fmt.Errorf("conflicts with %s %s", constants.EndpointKey, endpoint)
+	}
+	if token := cfg[constants.TokenKey]; token != "" && token != conn.InstrumentationKey {
+		return fmt.Errorf("conflicts with the instrumentation key in %s", constants.TokenKey)
+	}
 	return nil
 }
 
+// parseConnectionString parses a connection string such as
+// InstrumentationKey=00000000-0000-0000-0000-000000000000;IngestionEndpoint=https://westus2-0.in.applicationinsights.azure.com/
+func parseConnectionString(val string) (connectionString, error) {
+	var (
+		conn           connectionString
+		ingestion      string
+		endpointSuffix string
+		location       string
+	)
+
+	for i, segment := range strings.Split(val, ";") {
+		segment = strings.TrimSpace(segment)
+		if segment == "" {
+			continue
+		}
+
+		pair := strings.SplitN(segment, "=", 2)
+		if len(pair) != 2 {
+			// The segment is not echoed as it may hold the instrumentation key
+			return connectionString{}, fmt.Errorf("malformed segment %d", i+1)
+		}
+
+		value := strings.TrimSpace(pair[1])
+		switch strings.ToLower(strings.TrimSpace(pair[0])) {
+		case "instrumentationkey":
+			conn.InstrumentationKey = value
+		case "ingestionendpoint":
+			ingestion = value
+		case "endpointsuffix":
+			endpointSuffix = value
+		case "location":
+			location = value
+		}
+	}
+
+	if conn.InstrumentationKey == "" {
+		return connectionString{}, fmt.Errorf("InstrumentationKey is expected")
+	}
+
+	// An explicit ingestion endpoint wins over one built from the endpoint suffix
+	switch {
+	case ingestion != "":
+	case endpointSuffix != "" && location != "":
+		ingestion = fmt.Sprintf("https://%s.dc.%s", location, strings.Trim(endpointSuffix, "./"))
+	case endpointSuffix != "":
+		ingestion = fmt.Sprintf("https://dc.%s", strings.Trim(endpointSuffix, "./"))
+	default:
+		ingestion = constants.Endpoint
+	}
+
+	if !strings.HasSuffix(strings.TrimRight(ingestion, "/"), constants.IngestionPath) {
+		ingestion = strings.TrimRight(ingestion, "/") + constants.IngestionPath
+	}
+
+	uri, err := parseURL(ingestion)
+	if err != nil {
+		return connectionString{}, err
+	}
+	conn.IngestionURL = uri.String()
+	return conn, nil
+}
+
 func getAdvancedOption(info logger.Info, name, def string) string {
 	val, ok := info.Config[name]
 	if val == "" || !ok {
@@ -72,41 +266,187 @@ func getAdvancedOption(info logger.Info, name, def string) string {
 	return val
 }
 
-func getAdvancedOptionDuration(info logger.Info, name string, def time.Duration) time.Duration {
+func getAdvancedOptionURL(info logger.Info, name, def string) (string, error) {
 	val, ok := info.Config[name]
 	if val == "" || !ok {
-		return def
+		return def, nil
+	}
+	if _, err := parseURL(val); err != nil {
+		return def, err
+	}
+	return val, nil
+}
+
+func getAdvancedOptionEnum(info logger.Info, name, def string, values []string) (string, error) {
+	val, ok := info.Config[name]
+	if val == "" || !ok {
+		return def, nil
+	}
+	if !containsString(values, val) {
+		return def, fmt.Errorf("must be one of %s, received %q", strings.Join(values, ", "), val)
+	}
+	return val, nil
+}
+
+func containsString(values []string, val string) bool {
+	for _, v := range values {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// getAdvancedOptionList parses a comma separated list, ignoring blank entries.
+// When values is not empty every entry must be one of values.
+func getAdvancedOptionList(info logger.Info, name string, def, values []string) ([]string, error) {
+	val, ok := info.Config[name]
+	if val == "" || !ok {
+		return def, nil
+	}
+	var list []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if len(values) > 0 && !containsString(values, item) {
+			return def, fmt.Errorf("entries must be one of %s, received %q", strings.Join(values, ", "), item)
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func getAdvancedOptionDuration(info logger.Info, name string, def, min time.Duration) (time.Duration, error) {
+	val, ok := info.Config[name]
+	if val == "" || !ok {
+		return def, nil
 	}
 	parsed, err := time.ParseDuration(val)
 	if err != nil {
-		logrus.Errorf("failed to parse value of %s as duration. Using default %v. %v", name, def, err)
-		return def
+		return def, fmt.Errorf("failed to parse %q as duration", val)
+	}
+	if parsed < min {
+		return def, fmt.Errorf("must be at least %v, received %v", min, parsed)
 	}
-	return parsed
+	return parsed, nil
 }
 
-func getAdvancedOptionInt(info logger.Info, name string, def int) int {
+func getAdvancedOptionInt(info logger.Info, name string, def, min, max int) (int, error) {
 	val, ok := info.Config[name]
 	if val == "" || !ok {
-		return def
+		return def, nil
 	}
 	parsed, err := strconv.ParseInt(val, 10, 32)
 	if err != nil {
-		logrus.Errorf("failed to parse value of %s as duration. Using default %v. %v", name, def, err)
-		return def
+		return def, fmt.Errorf("failed to parse %q as integer", val)
+	}
+	if int(parsed) < min || int(parsed) > max {
+		return def, fmt.Errorf("must be between %d and %d, received %d", min, max, parsed)
 	}
-	return int(parsed)
+	return int(parsed), nil
 }
 
-func getAdvancedOptionBool(info logger.Info, name string, def bool) bool {
+func getAdvancedOptionFloat(info logger.Info, name string, def, min, max float64) (float64, error) {
 	val, ok := info.Config[name]
 	if val == "" || !ok {
-		return def
+		return def, nil
+	}
+	parsed, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return def, fmt.Errorf("failed to parse %q as number", val)
+	}
+	if parsed < min || parsed > max {
+		return def, fmt.Errorf("must be between %v and %v, received %v", min, max, parsed)
+	}
+	return parsed, nil
+}
+
+func getAdvancedOptionSeverity(info logger.Info, name string, def ai.SeverityLevel) (ai.SeverityLevel, error) {
+	val, ok := info.Config[name]
+	if val == "" || !ok {
+		return def, nil
+	}
+	parsed, err := parseSeverity(val)
+	if err != nil {
+		return def, err
+	}
+	return parsed, nil
+}
+
+func getAdvancedOptionSeverityRules(info logger.Info, name string, def []SeverityRule) ([]SeverityRule, error) {
+	val, ok := info.Config[name]
+	if val == "" || !ok {
+		return def, nil
+	}
+	parsed, err := parseSeverityRules(val)
+	if err != nil {
+		return def, err
+	}
+	return parsed, nil
+}
+
+func getAdvancedOptionSeverityRates(info logger.Info, name string, def map[ai.SeverityLevel]float64) (map[ai.SeverityLevel]float64, error) {
+	val, ok := info.Config[name]
+	if val == "" || !ok {
+		return def, nil
+	}
+	parsed, err := parseSeveritySampleRates(val)
+	if err != nil {
+		return def, err
+	}
+	return parsed, nil
+}
+
+func getAdvancedOptionPatterns(info logger.Info, name string, def []*regexp.Regexp) ([]*regexp.Regexp, error) {
+	val, ok := info.Config[name]
+	if val == "" || !ok {
+		return def, nil
+	}
+	parsed, err := parseRedactPatterns(val)
+	if err != nil {
+		return def, err
+	}
+	return parsed, nil
+}
+
+func getAdvancedOptionMetricRules(info logger.Info, name string, def []MetricRule) ([]MetricRule, error) {
+	val, ok := info.Config[name]
+	if val == "" || !ok {
+		return def, nil
+	}
+	parsed, err := parseMetricRules(val)
+	if err != nil {
+		return def, err
+	}
+	return parsed, nil
+}
+
+func getAdvancedOptionPattern(info logger.Info, name string, def *regexp.Regexp, groups []string) (*regexp.Regexp, error) {
+	val, ok := info.Config[name]
+	if val == "" || !ok {
+		return def, nil
+	}
+	parsed, err := parsePattern(val)
+	if err != nil {
+		return def, err
+	}
+	for _, group := range groups {
+		if !containsString(parsed.SubexpNames(), group) {
+			return def, fmt.Errorf("expected a group named %s", group)
+		}
+	}
+	return parsed, nil
+}
+
+func getAdvancedOptionBool(info logger.Info, name string, def bool) (bool, error) {
+	val, ok := info.Config[name]
+	if val == "" || !ok {
+		return def, nil
 	}
 	parsed, err := strconv.ParseBool(val)
 	if err != nil {
-		logrus.Errorf("Failed to parse value of %s as duration. Using default %v. %v", name, def, err)
-		return def
+		return def, fmt.Errorf("failed to parse %q as boolean", val)
 	}
-	return parsed
+	return parsed, nil
 }
@@ -6,6 +6,8 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/docker/docker/daemon/logger"
 	"time"
+
+	"github.com/Microsoft/ApplicationInsights-Go/appinsights/contracts"
 )
 
 func copyConfig(src logger.Info) logger.Info {
@@ -29,25 +31,55 @@ func TestInitializeEnv(t *testing.T) {
 			constants.BatchIntervalKey:        "",
 		},
 	}
-	err := InitializeEnv(allValuesEmpty)
+	_, err := InitializeEnv(allValuesEmpty, DefaultConfig())
 	require.NoError(t, err)
 
 
 	emptyConfig := logger.Info { Config: map[string]string{} }
-	err = InitializeEnv(emptyConfig)
+	_, err = InitializeEnv(emptyConfig, DefaultConfig())
 	require.Error(t, err)
 
 	badKey := copyConfig(allValuesEmpty)
 	badKey.Config["Some Weird Key"] = ""
-	err = InitializeEnv(badKey)
+	_, err = InitializeEnv(badKey, DefaultConfig())
 	require.Error(t, err)
 
 	noToken := copyConfig(allValuesEmpty)
 	delete(noToken.Config, constants.TokenKey)
-	err = InitializeEnv(noToken)
+	_, err = InitializeEnv(noToken, DefaultConfig())
 	require.Error(t, err)
 }
 
+func TestInitializeEnvIsolatesLoggers(t *testing.T) {
+	defaults := DefaultConfig()
+
+	first, err := InitializeEnv(logger.Info{
+		Config: map[string]string{
+			constants.TokenKey:     "first token",
+			constants.EndpointKey:  "https://first.example.com/v2/track",
+			constants.BatchSizeKey: "10",
+		},
+	}, defaults)
+	require.NoError(t, err)
+
+	second, err := InitializeEnv(logger.Info{
+		Config: map[string]string{
+			constants.TokenKey: "second token",
+		},
+	}, defaults)
+	require.NoError(t, err)
+
+	require.Equal(t, "first token", first.Token)
+	require.Equal(t, "https://first.example.com/v2/track", first.Endpoint)
+	require.Equal(t, 10, first.BatchSize)
+
+	require.Equal(t, "second token", second.Token)
+	require.Equal(t, constants.Endpoint, second.Endpoint)
+	require.Equal(t, constants.BatchSize, second.BatchSize)
+
+	require.Equal(t, DefaultConfig(), defaults)
+}
+
 func TestValidateLogOpt(t *testing.T) {
 	allSuccess := make(map[string]string)
 	allSuccess[constants.EndpointKey] = ""
@@ -95,6 +127,30 @@ func TestGetAdvancedOption(t *testing.T) {
 	require.Equal(t, def, res)
 }
 
+func TestGetAdvancedOptionURL(t *testing.T) {
+	key := "key"
+	def := constants.Endpoint
+
+	info := logger.Info{
+		Config: map[string]string{
+			key: "https://example.com/v2/track",
+		},
+	}
+
+	res, err := getAdvancedOptionURL(info, key, def)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/v2/track", res)
+
+	delete(info.Config, "key")
+	res, err = getAdvancedOptionURL(info, key, def)
+	require.NoError(t, err)
+	require.Equal(t, def, res)
+
+	info.Config[key] = "https://example.com"
+	_, err = getAdvancedOptionURL(info, key, def)
+	require.Error(t, err)
+}
+
 func TestGetAdvancedOptionDuration(t *testing.T) {
 	key := "key"
 	valStr := "5s"
@@ -107,16 +163,22 @@ func TestGetAdvancedOptionDuration(t *testing.T) {
 		},
 	}
 
-	res := getAdvancedOptionDuration(info, key, def)
+	res, err := getAdvancedOptionDuration(info, key, def, time.Second)
+	require.NoError(t, err)
 	require.Equal(t, val, res)
 
 	delete(info.Config, "key")
-	res = getAdvancedOptionDuration(info, key, def)
+	res, err = getAdvancedOptionDuration(info, key, def, time.Second)
+	require.NoError(t, err)
 	require.Equal(t, def, res)
 
 	info.Config[key] = "bad val"
-	res = getAdvancedOptionDuration(info, key, def)
-	require.Equal(t, def, res)
+	_, err = getAdvancedOptionDuration(info, key, def, time.Second)
+	require.Error(t, err)
+
+	info.Config[key] = "0s"
+	_, err = getAdvancedOptionDuration(info, key, def, time.Second)
+	require.Error(t, err)
 }
 
 func TestGetAdvancedOptionInt(t *testing.T) {
@@ -129,16 +191,22 @@ func TestGetAdvancedOptionInt(t *testing.T) {
 		},
 	}
 
-	res := getAdvancedOptionInt(info, key, 6)
+	res, err := getAdvancedOptionInt(info, key, 6, 1, 10)
+	require.NoError(t, err)
 	require.Equal(t, 5, res)
 
 	delete(info.Config, "key")
-	res = getAdvancedOptionInt(info, key, 6)
+	res, err = getAdvancedOptionInt(info, key, 6, 1, 10)
+	require.NoError(t, err)
 	require.Equal(t, 6, res)
 
 	info.Config[key] = "bad val"
-	res = getAdvancedOptionInt(info, key, 6)
-	require.Equal(t, 6, res)
+	_, err = getAdvancedOptionInt(info, key, 6, 1, 10)
+	require.Error(t, err)
+
+	info.Config[key] = "42"
+	_, err = getAdvancedOptionInt(info, key, 6, 1, 10)
+	require.Error(t, err)
 }
 
 func TestGetAdvancedOptionBool(t *testing.T) {
@@ -151,14 +219,167 @@ func TestGetAdvancedOptionBool(t *testing.T) {
 		},
 	}
 
-	res := getAdvancedOptionBool(info, key, false)
+	res, err := getAdvancedOptionBool(info, key, false)
+	require.NoError(t, err)
 	require.Equal(t, true, res)
 
 	delete(info.Config, "key")
-	res = getAdvancedOptionBool(info, key, false)
+	res, err = getAdvancedOptionBool(info, key, false)
+	require.NoError(t, err)
 	require.Equal(t, false, res)
 
 	info.Config[key] = "bad val"
-	res = getAdvancedOptionBool(info, key, false)
-	require.Equal(t, false, res)
+	_, err = getAdvancedOptionBool(info, key, false)
+	require.Error(t, err)
+}
+
+func TestValidateLogOptReportsEveryProblem(t *testing.T) {
+	cfg := map[string]string{
+		constants.TokenKey:                "some token",
+		constants.EndpointKey:             "not a url",
+		constants.GzipCompressionKey:      "yes please",
+		constants.GzipCompressionLevelKey: "42",
+		constants.BatchSizeKey:            "abc",
+		constants.BatchIntervalKey:        "-5s",
+		"some param":                      "",
+	}
+
+	err := validateLogOpt(cfg)
+	require.Error(t, err)
+
+	errs, ok := err.(optionErrors)
+	require.True(t, ok)
+	require.Len(t, errs, 6)
+	for _, key := range []string{
+		constants.EndpointKey,
+		constants.GzipCompressionKey,
+		constants.GzipCompressionLevelKey,
+		constants.BatchSizeKey,
+		constants.BatchIntervalKey,
+		"some param",
+	} {
+		require.Contains(t, err.Error(), key)
+	}
+
+	_, err = InitializeEnv(logger.Info{Config: cfg}, DefaultConfig())
+	require.Error(t, err)
+}
+
+func TestParseConnectionString(t *testing.T) {
+	conn, err := parseConnectionString("InstrumentationKey=some key;IngestionEndpoint=https://westus2-0.in.applicationinsights.azure.com/")
+	require.NoError(t, err)
+	require.Equal(t, "some key", conn.InstrumentationKey)
+	require.Equal(t, "https://westus2-0.in.applicationinsights.azure.com/v2/track", conn.IngestionURL)
+
+	conn, err = parseConnectionString("instrumentationkey=some key;ingestionendpoint=https://westus2-0.in.applicationinsights.azure.com/v2/track;")
+	require.NoError(t, err)
+	require.Equal(t, "https://westus2-0.in.applicationinsights.azure.com/v2/track", conn.IngestionURL)
+
+	conn, err = parseConnectionString("InstrumentationKey=some key;EndpointSuffix=applicationinsights.azure.cn;Location=chinaeast2")
+	require.NoError(t, err)
+	require.Equal(t, "https://chinaeast2.dc.applicationinsights.azure.cn/v2/track", conn.IngestionURL)
+
+	conn, err = parseConnectionString("InstrumentationKey=some key")
+	require.NoError(t, err)
+	require.Equal(t, constants.Endpoint, conn.IngestionURL)
+
+	invalid := []string{
+		"",
+		"IngestionEndpoint=https://westus2-0.in.applicationinsights.azure.com/",
+		"InstrumentationKey=some key;IngestionEndpoint",
+		"InstrumentationKey=some key;IngestionEndpoint=not a url",
+	}
+
+	for _, val := range invalid {
+		_, err = parseConnectionString(val)
+		require.Error(t, err)
+	}
+}
+
+func TestConnectionStringLogOpt(t *testing.T) {
+	connStr := "InstrumentationKey=some key;IngestionEndpoint=https://westus2-0.in.applicationinsights.azure.com/"
+
+	info := logger.Info{
+		Config: map[string]string{
+			constants.ConnectionStringKey: connStr,
+		},
+	}
+	config, err := InitializeEnv(info, DefaultConfig())
+	require.NoError(t, err)
+	require.Equal(t, "some key", config.Token)
+	require.Equal(t, "https://westus2-0.in.applicationinsights.azure.com/v2/track", config.Endpoint)
+
+	matching := copyConfig(info)
+	matching.Config[constants.TokenKey] = "some key"
+	_, err = InitializeEnv(matching, DefaultConfig())
+	require.NoError(t, err)
+
+	conflictingToken := copyConfig(info)
+	conflictingToken.Config[constants.TokenKey] = "other key"
+	require.Error(t, validateLogOpt(conflictingToken.Config))
+
+	conflictingEndpoint := copyConfig(info)
+	conflictingEndpoint.Config[constants.EndpointKey] = constants.Endpoint
+	require.Error(t, validateLogOpt(conflictingEndpoint.Config))
+
+	// A connection string from the plugin defaults is overridden by the container token
+	defaults, err := MergeDefaults(DefaultConfig(), map[string]string{constants.ConnectionStringKey: connStr}, SourceFile)
+	require.NoError(t, err)
+	config, err = InitializeEnv(logger.Info{Config: map[string]string{constants.TokenKey: "container key"}}, defaults)
+	require.NoError(t, err)
+	require.Equal(t, "container key", config.Token)
+	require.Equal(t, "https://westus2-0.in.applicationinsights.azure.com/v2/track", config.Endpoint)
+	require.Equal(t, SourceLogOpt, config.Source(constants.TokenKey))
+	require.Equal(t, SourceFile, config.Source(constants.EndpointKey))
+}
+
+func TestContainerLabelOverrides(t *testing.T) {
+	info := logger.Info{
+		Config: map[string]string{
+			constants.TokenKey:       "some token",
+			constants.RoleKey:        "log opt role",
+			constants.SampleRateKey:  "50",
+			constants.MinSeverityKey: "warning",
+		},
+		ContainerLabels: map[string]string{
+			constants.LabelPrefix + constants.RoleKey:        "label role",
+			constants.LabelPrefix + constants.MinSeverityKey: "error",
+			"com.docker.compose.service":                     "web",
+		},
+	}
+
+	config, err := InitializeEnv(info, DefaultConfig())
+	require.NoError(t, err)
+	require.Equal(t, "label role", config.Role)
+	require.Equal(t, contracts.Error, config.MinSeverity)
+	require.Equal(t, 50.0, config.SampleRate)
+	require.Equal(t, SourceLabel, config.Source(constants.RoleKey))
+	require.Equal(t, SourceLogOpt, config.Source(constants.SampleRateKey))
+
+	notAllowed := copyConfig(info)
+	notAllowed.ContainerLabels = map[string]string{
+		constants.LabelPrefix + constants.EndpointKey: "https://attacker.example.com/v2/track",
+	}
+	_, err = InitializeEnv(notAllowed, DefaultConfig())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), constants.LabelPrefix+constants.EndpointKey)
+
+	// Labels must not widen the metadata sent or weaken the redaction of the plugin defaults
+	for _, key := range []string{
+		constants.IncludeFieldsKey, constants.ExcludeFieldsKey, constants.EnvAllowlistKey,
+		constants.RedactKey, constants.RedactModeKey, constants.RedactPatternsKey, constants.RedactLocalKey,
+	} {
+		notAllowed.ContainerLabels = map[string]string{constants.LabelPrefix + key: ""}
+		_, err = InitializeEnv(notAllowed, DefaultConfig())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), constants.LabelPrefix+key)
+	}
+
+	invalid := copyConfig(info)
+	invalid.ContainerLabels = map[string]string{
+		constants.LabelPrefix + constants.SampleRateKey: "150",
+	}
+	_, err = InitializeEnv(invalid, DefaultConfig())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), constants.LabelPrefix+constants.SampleRateKey)
 }
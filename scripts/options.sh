@@ -0,0 +1,12 @@
+#!/bin/bash
+#
+# Regenerate the log options table in the README from the option registry
+
+README="${README:-README.md}"
+TABLE=$(go run main.go options --format markdown) || exit 1
+
+awk -v table="$TABLE" '
+    /<!-- options -->/ { print; print table; skip = 1; next }
+    /<!-- \/options -->/ { skip = 0 }
+    !skip { print }
+' "$README" > "$README.tmp" && mv "$README.tmp" "$README"